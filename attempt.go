@@ -0,0 +1,263 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Attempt records the outcome of a single attempt made by Client.Do.
+type Attempt struct {
+	// Number is the attempt number, starting at 1 for the initial request.
+	Number int
+
+	// StatusCode is the status code of the response, or 0 if no response
+	// was received.
+	StatusCode int
+
+	// Err is the error returned by the HTTP client or by the request's
+	// ResponseHandlerFunc, if any.
+	Err error
+
+	// ShouldRetry and CheckErr are the values returned by CheckRetry for
+	// this attempt.
+	ShouldRetry bool
+	CheckErr    error
+
+	// Reason is the reason given by a CheckRetryV2 policy for its decision.
+	Reason string
+
+	// Wait is the backoff computed after this attempt. It is zero if no
+	// further attempt was scheduled.
+	Wait time.Duration
+
+	// Start is the time the attempt was sent and Duration is how long it
+	// took until a response or error was received.
+	Start    time.Time
+	Duration time.Duration
+}
+
+// AttemptInfo describes an attempt made by Client.Do.
+type AttemptInfo struct {
+	// Number is the attempt number, starting at 1 for the initial request.
+	Number int
+
+	// MaxAttempts is the maximum number of attempts Client.Do will make,
+	// or 0 if the attempts are only bounded by Client.MaxElapsedTime.
+	MaxAttempts int
+
+	// Elapsed is the time since the first attempt was sent.
+	Elapsed time.Duration
+
+	// PrevStatus and PrevErr are the status code and error of the previous
+	// attempt. They are zero for the first attempt.
+	PrevStatus int
+	PrevErr    error
+
+	// PrevResponse is the response to the previous attempt, if any. Its
+	// body has already been consumed, but its headers can be inspected.
+	PrevResponse *http.Response
+
+	// IdempotencyKey is the idempotency key sent with the attempt, if any.
+	// See Client.IdempotencyKey.
+	IdempotencyKey string
+}
+
+// AttemptFromContext returns the AttemptInfo of the attempt made by
+// Client.Do with the given context. Client.Do gives every attempt its own
+// context, which is seen by the transport of the HTTP client, by
+// RequestLogHook, CheckRetry and ResponseHandlerFunc, and, with the
+// information of the upcoming attempt, by PrepareRetry. The returned bool is
+// false if ctx does not belong to an attempt.
+func AttemptFromContext(ctx context.Context) (AttemptInfo, bool) {
+	if state := attemptStateFromContext(ctx); state != nil {
+		return state.attempt, true
+	}
+	return AttemptInfo{}, false
+}
+
+// RetryInfo describes the attempts made by Client.DoWithInfo to complete a
+// request, whether or not it eventually succeeded.
+type RetryInfo struct {
+	// Attempts holds the history of every attempt, in order.
+	Attempts []Attempt
+}
+
+// NumAttempts returns the number of attempts that were made.
+func (i *RetryInfo) NumAttempts() int {
+	return len(i.Attempts)
+}
+
+// Retried reports whether more than one attempt was needed.
+func (i *RetryInfo) Retried() bool {
+	return len(i.Attempts) > 1
+}
+
+// Failed returns the attempts which returned an error or which CheckRetry
+// asked to retry.
+func (i *RetryInfo) Failed() []Attempt {
+	var failed []Attempt
+	for _, a := range i.Attempts {
+		if a.Err != nil || a.ShouldRetry {
+			failed = append(failed, a)
+		}
+	}
+	return failed
+}
+
+// TotalWait returns the total time spent waiting between attempts.
+func (i *RetryInfo) TotalWait() time.Duration {
+	var total time.Duration
+	for _, a := range i.Attempts {
+		total += a.Wait
+	}
+	return total
+}
+
+// attemptStateKey is the context key under which Client.Do stores the
+// attemptState of the attempt in progress.
+type attemptStateKey struct{}
+
+// attemptState holds what Client.Do knows about the attempt in progress. It
+// is stored in the context of each attempt so that retry policies, which only
+// receive the context, can inspect it.
+type attemptState struct {
+	req       *http.Request
+	attempt   AttemptInfo
+	keyHeader string
+	rnd       Rand
+	clock     Clock
+
+	// wroteRequest is set once the HTTP client has finished writing the
+	// request, or tried to. Until then the server cannot have processed it.
+	wroteRequest atomic.Bool
+}
+
+// newAttemptContext returns a child of ctx carrying a fresh attemptState for
+// the given attempt of req made by c.
+func newAttemptContext(ctx context.Context, req *http.Request, attempt AttemptInfo, c *Client) context.Context {
+	state := &attemptState{
+		req:       req,
+		attempt:   attempt,
+		keyHeader: c.idempotencyKeyHeader(),
+		rnd:       c.rnd(),
+		clock:     c.clock(),
+	}
+	ctx = context.WithValue(ctx, attemptStateKey{}, state)
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			state.wroteRequest.Store(true)
+		},
+	})
+}
+
+// attemptStateFromContext returns the attemptState stored in ctx, or nil if
+// ctx does not belong to an attempt made by Client.Do.
+func attemptStateFromContext(ctx context.Context) *attemptState {
+	state, _ := ctx.Value(attemptStateKey{}).(*attemptState)
+	return state
+}
+
+// setAttemptHeaders sets the AttemptHeader and DeadlineHeader of the client,
+// if configured, on req, which must be a copy of the request made for the
+// attempt. The headers are cloned first so that those of the caller are left
+// untouched.
+func (c *Client) setAttemptHeaders(req *http.Request, attempt AttemptInfo) *http.Request {
+	if c.AttemptHeader == "" && c.DeadlineHeader == "" {
+		return req
+	}
+
+	header := req.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	if c.AttemptHeader != "" {
+		header.Set(c.AttemptHeader, strconv.Itoa(attempt.Number))
+	}
+	if c.DeadlineHeader != "" {
+		if deadline, ok := req.Context().Deadline(); ok {
+			header.Set(c.DeadlineHeader, formatTimeout(deadline.Sub(timeNow())))
+		} else {
+			header.Del(c.DeadlineHeader)
+		}
+	}
+	req.Header = header
+	return req
+}
+
+// formatTimeout formats d like the grpc-timeout header: at most 8 digits
+// followed by a unit, using the finest unit which fits. Durations are
+// rounded up so that the server is never told it has less time than it has,
+// and negative durations are sent as zero.
+func formatTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "0n"
+	}
+	const maxValue = 1e8 - 1
+	units := []struct {
+		size time.Duration
+		name string
+	}{
+		{time.Nanosecond, "n"},
+		{time.Microsecond, "u"},
+		{time.Millisecond, "m"},
+		{time.Second, "S"},
+		{time.Minute, "M"},
+		{time.Hour, "H"},
+	}
+	for _, unit := range units {
+		value := d / unit.size
+		if d%unit.size != 0 {
+			value++
+		}
+		if value <= maxValue {
+			return strconv.FormatInt(int64(value), 10) + unit.name
+		}
+	}
+	return strconv.FormatInt(maxValue, 10) + "H"
+}
+
+// finishAttempt ties the context bounding an attempt, timeoutCtx, to the
+// outcome of the attempt. If the attempt timed out while ctx is still alive,
+// the error is replaced by ErrAttemptTimeout. Otherwise cancel is deferred
+// until the response body is closed, so that the body can still be read.
+func finishAttempt(ctx, timeoutCtx context.Context, cancel context.CancelFunc, resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		if timeoutCtx.Err() != nil && ctx.Err() == nil {
+			if urlErr, ok := err.(*url.Error); ok {
+				urlErr.Err = ErrAttemptTimeout
+			} else {
+				err = ErrAttemptTimeout
+			}
+		}
+		cancel()
+		return resp, err
+	}
+	if resp == nil || resp.Body == nil || timeoutCtx == ctx {
+		cancel()
+		return resp, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, err
+}
+
+// cancelOnClose is a response body which cancels the context of its attempt
+// when closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
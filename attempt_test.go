@@ -0,0 +1,316 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_DoWithInfo(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&requests, 1) {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = 10 * time.Millisecond
+	client.RetryWaitMax = 10 * time.Millisecond
+
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	resp, info, err := client.DoWithInfo(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if info.NumAttempts() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", info.NumAttempts())
+	}
+	if !info.Retried() {
+		t.Fatal("expected the request to have been retried")
+	}
+	if info.TotalWait() != 20*time.Millisecond {
+		t.Fatalf("expected 20ms of waiting, got %s", info.TotalWait())
+	}
+
+	failed := info.Failed()
+	if len(failed) != 2 {
+		t.Fatalf("expected 2 failed attempts, got %d", len(failed))
+	}
+	if failed[0].StatusCode != http.StatusServiceUnavailable || failed[1].StatusCode != http.StatusBadGateway {
+		t.Fatalf("unexpected failed attempts: %#v", failed)
+	}
+	if last := info.Attempts[2]; last.StatusCode != http.StatusOK || last.ShouldRetry || last.Wait != 0 {
+		t.Fatalf("unexpected final attempt: %#v", last)
+	}
+}
+
+func TestClient_DoWithInfo_fails(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.RetryMax = 1
+
+	req, err := NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	_, info, err := client.DoWithInfo(req)
+	if !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("expected ErrRetriesExhausted, got %v", err)
+	}
+	if info == nil || info.NumAttempts() != 2 || len(info.Failed()) != 2 {
+		t.Fatalf("unexpected info: %#v", info)
+	}
+}
+
+// roundTripperFunc adapts a function to http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestAttemptFromContext(t *testing.T) {
+	if _, ok := AttemptFromContext(context.Background()); ok {
+		t.Fatalf("expected no attempt in a background context")
+	}
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	// Every reading of the clock advances it by a second.
+	var ticks int64
+	timeNow = func() time.Time {
+		return time.Unix(atomic.AddInt64(&ticks, 1), 0)
+	}
+	t.Cleanup(func() { timeNow = time.Now })
+
+	var transport, prepared, handled []AttemptInfo
+	client := NewClient()
+	client.RetryMax = 3
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.IdempotencyKey = func() (string, error) { return "key-1", nil }
+	inner := client.HTTPClient.Transport
+	client.HTTPClient.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		attempt, ok := AttemptFromContext(req.Context())
+		if !ok {
+			t.Fatalf("expected the transport to see the attempt")
+		}
+		transport = append(transport, attempt)
+		return inner.RoundTrip(req)
+	})
+	client.PrepareRetry = func(req *http.Request) error {
+		attempt, _ := AttemptFromContext(req.Context())
+		prepared = append(prepared, attempt)
+		return nil
+	}
+
+	req := mustNewRequest(t, "POST", ts.URL)
+	req.SetResponseHandler(func(resp *http.Response) error {
+		attempt, _ := AttemptFromContext(resp.Request.Context())
+		handled = append(handled, attempt)
+		return nil
+	})
+	if _, err := client.Do(req); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if len(transport) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(transport))
+	}
+	for i, attempt := range transport {
+		if attempt.Number != i+1 || attempt.MaxAttempts != 4 || attempt.IdempotencyKey != "key-1" {
+			t.Fatalf("unexpected attempt %#v", attempt)
+		}
+		if i == 0 && (attempt.PrevStatus != 0 || attempt.PrevErr != nil) {
+			t.Fatalf("expected no previous outcome for the first attempt: %#v", attempt)
+		}
+		if i > 0 && attempt.PrevStatus != http.StatusServiceUnavailable {
+			t.Fatalf("expected the previous status to be 503: %#v", attempt)
+		}
+		if i > 0 && attempt.Elapsed <= transport[i-1].Elapsed {
+			t.Fatalf("expected the elapsed time to grow: %#v", attempt)
+		}
+	}
+	if len(prepared) != 2 || prepared[0].Number != 2 || prepared[1].Number != 3 {
+		t.Fatalf("expected PrepareRetry to see the upcoming attempts, got %#v", prepared)
+	}
+	if len(handled) != 1 || handled[0].Number != 3 {
+		t.Fatalf("expected the response handler to see the last attempt, got %#v", handled)
+	}
+}
+
+func TestFormatTimeout(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{-time.Second, "0n"},
+		{0, "0n"},
+		{1500 * time.Nanosecond, "1500n"},
+		{99999999 * time.Nanosecond, "99999999n"},
+		{100 * time.Millisecond, "100000u"},
+		{2500 * time.Millisecond, "2500000u"},
+		{100*time.Second + time.Nanosecond, "100001m"},
+		{48 * time.Hour, "172800S"},
+		{time.Duration(1<<63 - 1), "2562048H"},
+	}
+	for _, tt := range tests {
+		if got := formatTimeout(tt.d); got != tt.want {
+			t.Fatalf("formatTimeout(%s): expected %q, got %q", tt.d, tt.want, got)
+		}
+	}
+}
+
+func TestClient_AttemptHeaders(t *testing.T) {
+	var attempts, deadlines []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts = append(attempts, r.Header.Get("X-Retry-Attempt"))
+		deadlines = append(deadlines, r.Header.Get("X-Request-Timeout"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryMax = 2
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.AttemptHeader = "X-Retry-Attempt"
+	client.DeadlineHeader = "X-Request-Timeout"
+
+	req := mustNewRequest(t, "GET", ts.URL)
+	req.Header.Set("X-Request-Timeout", "stale")
+	client.Do(req)
+	if got := fmt.Sprint(attempts); got != "[1 2 3]" {
+		t.Fatalf("unexpected attempt headers %s", got)
+	}
+	if got := fmt.Sprint(deadlines); got != "[  ]" {
+		t.Fatalf("expected no deadline header without a deadline, got %q", deadlines)
+	}
+	if req.Header.Get("X-Retry-Attempt") != "" || req.Header.Get("X-Request-Timeout") != "stale" {
+		t.Fatalf("expected the caller's headers to be left untouched: %v", req.Header)
+	}
+
+	// The remaining time shrinks from one attempt to the next.
+	var ticks int64
+	base := time.Now()
+	timeNow = func() time.Time {
+		return base.Add(time.Duration(atomic.AddInt64(&ticks, 1)) * time.Second)
+	}
+	t.Cleanup(func() { timeNow = time.Now })
+
+	attempts, deadlines = nil, nil
+	ctx, cancel := context.WithDeadline(context.Background(), base.Add(time.Minute))
+	defer cancel()
+	client.Do(mustNewRequest(t, "GET", ts.URL).WithContext(ctx))
+	if len(deadlines) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(deadlines))
+	}
+	prev := time.Hour
+	for _, v := range deadlines {
+		d, err := time.ParseDuration(strings.TrimSuffix(v, "S") + "s")
+		if err != nil || d <= 0 || d >= prev {
+			t.Fatalf("expected a shrinking deadline, got %q", deadlines)
+		}
+		prev = d
+	}
+}
+
+func TestClient_AttemptTimeout(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every attempt takes longer than the one before.
+		delay := time.Duration(atomic.AddInt32(&requests, 1)) * 30 * time.Millisecond
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(delay):
+		}
+		w.Write([]byte("done"))
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryMax = 3
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.AttemptTimeout = 10 * time.Millisecond
+	client.AttemptTimeoutEscalation = 10
+
+	resp, info, err := client.DoWithInfo(mustNewRequest(t, "GET", ts.URL))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "done" {
+		t.Fatalf("expected to read the body, got %q, %v", body, err)
+	}
+	if info.NumAttempts() != 2 {
+		t.Fatalf("expected 2 attempts, got %d", info.NumAttempts())
+	}
+	first := info.Attempts[0]
+	if !errors.Is(first.Err, ErrAttemptTimeout) || errors.Is(first.Err, context.DeadlineExceeded) || !first.ShouldRetry {
+		t.Fatalf("expected a retryable attempt timeout, got %#v", first)
+	}
+
+	// Without escalation every attempt times out.
+	atomic.StoreInt32(&requests, 0)
+	client.AttemptTimeoutEscalation = 0
+	_, err = client.Get(ts.URL)
+	if !errors.Is(err, ErrRetriesExhausted) || !errors.Is(err, ErrAttemptTimeout) {
+		t.Fatalf("expected the retries to be exhausted by timeouts, got %v", err)
+	}
+
+	// A per-request override lifts the timeout, and the caller's deadline
+	// is reported as such.
+	atomic.StoreInt32(&requests, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Millisecond)
+	defer cancel()
+	req := mustNewRequest(t, "GET", ts.URL).WithContext(ctx)
+	req.SetAttemptTimeout(0)
+	_, info, err = client.DoWithInfo(req)
+	if !errors.Is(err, context.DeadlineExceeded) || errors.Is(err, ErrAttemptTimeout) {
+		t.Fatalf("expected the caller's deadline to be exceeded, got %v", err)
+	}
+	if info.NumAttempts() != 1 {
+		t.Fatalf("expected a single attempt, got %d", info.NumAttempts())
+	}
+}
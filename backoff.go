@@ -0,0 +1,231 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"math"
+	"net/http"
+	"time"
+)
+
+// BackoffStrategy is a stateful alternative to Backoff, for algorithms which
+// depend on the waits that came before, such as decorrelated jitter.
+//
+// Client.Do obtains a strategy from Client.NewBackoffStrategy for each
+// request and calls Reset before its first retry, so a strategy only needs
+// to be safe for use by one request at a time.
+type BackoffStrategy interface {
+	// Next returns how long to wait before the given attempt. The attempt
+	// is the upcoming one, so attempt.Number is at least 2, and PrevStatus,
+	// PrevErr and PrevResponse describe the attempt which failed.
+	Next(attempt AttemptInfo) time.Duration
+
+	// Reset returns the strategy to its initial state.
+	Reset()
+}
+
+// BackoffFunc returns a BackoffStrategy which waits as long as the given
+// Backoff with the given minimum and maximum waits.
+func BackoffFunc(backoff Backoff, min, max time.Duration) BackoffStrategy {
+	return &backoffFunc{backoff: resolveBackoff(backoff), min: min, max: max, rnd: defaultRand}
+}
+
+type backoffFunc struct {
+	backoff  randBackoff
+	min, max time.Duration
+	rnd      Rand
+}
+
+func (b *backoffFunc) Next(attempt AttemptInfo) time.Duration {
+	return b.backoff(b.rnd, b.min, b.max, attempt.Number-2, attempt.PrevResponse)
+}
+
+func (b *backoffFunc) setRand(rnd Rand) { b.rnd = rnd }
+
+func (b *backoffFunc) Reset() {}
+
+// NewFullJitter returns a BackoffStrategy implementing "full jitter": the
+// wait before retry n is chosen at random between zero and base * 2^(n-1),
+// capped at max.
+func NewFullJitter(base, max time.Duration) BackoffStrategy {
+	return &fullJitter{base: base, max: max, rnd: defaultRand}
+}
+
+type fullJitter struct {
+	base, max time.Duration
+	rnd       Rand
+}
+
+func (b *fullJitter) Next(attempt AttemptInfo) time.Duration {
+	if sleep, ok := retryAfter(attempt.PrevResponse); ok {
+		return sleep
+	}
+	return randDuration(b.rnd, 0, exponential(b.base, b.max, attempt.Number-2))
+}
+
+func (b *fullJitter) setRand(rnd Rand) { b.rnd = rnd }
+
+func (b *fullJitter) Reset() {}
+
+// NewEqualJitter returns a BackoffStrategy implementing "equal jitter": the
+// wait before retry n is half of base * 2^(n-1), capped at max, plus a random
+// duration up to the other half.
+func NewEqualJitter(base, max time.Duration) BackoffStrategy {
+	return &equalJitter{base: base, max: max, rnd: defaultRand}
+}
+
+type equalJitter struct {
+	base, max time.Duration
+	rnd       Rand
+}
+
+func (b *equalJitter) Next(attempt AttemptInfo) time.Duration {
+	if sleep, ok := retryAfter(attempt.PrevResponse); ok {
+		return sleep
+	}
+	half := exponential(b.base, b.max, attempt.Number-2) / 2
+	return half + randDuration(b.rnd, 0, half)
+}
+
+func (b *equalJitter) setRand(rnd Rand) { b.rnd = rnd }
+
+func (b *equalJitter) Reset() {}
+
+// NewDecorrelatedJitter returns a BackoffStrategy implementing "decorrelated
+// jitter": each wait is chosen at random between base and three times the
+// previous wait, capped at max.
+func NewDecorrelatedJitter(base, max time.Duration) BackoffStrategy {
+	return &decorrelatedJitter{base: base, max: max, prev: base, rnd: defaultRand}
+}
+
+type decorrelatedJitter struct {
+	base, max time.Duration
+	prev      time.Duration
+	rnd       Rand
+}
+
+func (b *decorrelatedJitter) Next(attempt AttemptInfo) time.Duration {
+	if sleep, ok := retryAfter(attempt.PrevResponse); ok {
+		return sleep
+	}
+	upper := b.prev * 3
+	if upper < b.prev || upper > b.max {
+		upper = b.max
+	}
+	sleep := b.base
+	if upper > b.base {
+		sleep = randDuration(b.rnd, b.base, upper)
+	}
+	b.prev = sleep
+	return sleep
+}
+
+func (b *decorrelatedJitter) setRand(rnd Rand) { b.rnd = rnd }
+
+func (b *decorrelatedJitter) Reset() {
+	b.prev = b.base
+}
+
+// NewFibonacci returns a BackoffStrategy whose waits grow like the Fibonacci
+// sequence: base, base, 2*base, 3*base, 5*base and so on, capped at max.
+func NewFibonacci(base, max time.Duration) BackoffStrategy {
+	f := &fibonacci{base: base, max: max}
+	f.Reset()
+	return f
+}
+
+type fibonacci struct {
+	base, max time.Duration
+	cur, next time.Duration
+}
+
+func (b *fibonacci) Next(attempt AttemptInfo) time.Duration {
+	if sleep, ok := retryAfter(attempt.PrevResponse); ok {
+		return sleep
+	}
+	sleep := b.cur
+	if sleep > b.max {
+		sleep = b.max
+	}
+	// Stop growing once the cap is reached, so that the sequence can't
+	// overflow.
+	if b.cur < b.max {
+		sum := b.cur + b.next
+		if sum < b.next {
+			sum = b.max
+		}
+		b.cur, b.next = b.next, sum
+	}
+	return sleep
+}
+
+func (b *fibonacci) Reset() {
+	b.cur, b.next = b.base, b.base
+}
+
+// NewConstantJitter returns a BackoffStrategy which waits a constant
+// duration give or take a random jitter: each wait is chosen at random
+// between wait - jitter and wait + jitter, and is never negative.
+func NewConstantJitter(wait, jitter time.Duration) BackoffStrategy {
+	return &constantJitter{wait: wait, jitter: jitter, rnd: defaultRand}
+}
+
+type constantJitter struct {
+	wait, jitter time.Duration
+	rnd          Rand
+}
+
+func (b *constantJitter) Next(attempt AttemptInfo) time.Duration {
+	if sleep, ok := retryAfter(attempt.PrevResponse); ok {
+		return sleep
+	}
+	lower := b.wait - b.jitter
+	if lower < 0 {
+		lower = 0
+	}
+	return randDuration(b.rnd, lower, b.wait+b.jitter)
+}
+
+func (b *constantJitter) setRand(rnd Rand) { b.rnd = rnd }
+
+func (b *constantJitter) Reset() {}
+
+// retryAfter returns the wait requested by the Retry-After header of a 429
+// Too Many Requests or 503 Service Unavailable response. The bool returned
+// is false if resp is not such a response or carries no valid header.
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	now := timeNow()
+	if resp.Request != nil {
+		now = clockFromContext(resp.Request.Context()).Now()
+	}
+	return parseRetryAfterHeaderAt(resp.Header["Retry-After"], now)
+}
+
+// exponential returns min * 2^attemptNum, capped at max.
+func exponential(min, max time.Duration, attemptNum int) time.Duration {
+	mult := math.Pow(2, float64(attemptNum)) * float64(min)
+	sleep := time.Duration(mult)
+	if float64(sleep) != mult || sleep > max {
+		sleep = max
+	}
+	return sleep
+}
+
+// randDuration returns a random duration in [min, max] drawn from rnd.
+func randDuration(rnd Rand, min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	span := int64(max - min)
+	if span < math.MaxInt64 {
+		span++
+	}
+	return min + time.Duration(rnd.Int63n(span))
+}
@@ -0,0 +1,223 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sampleBackoff returns the mean, minimum and maximum of n waits returned by
+// strategy for the given attempt number.
+func sampleBackoff(strategy BackoffStrategy, number, n int) (mean float64, min, max time.Duration) {
+	min = time.Duration(math.MaxInt64)
+	for i := 0; i < n; i++ {
+		strategy.Reset()
+		wait := strategy.Next(AttemptInfo{Number: number})
+		mean += float64(wait) / float64(n)
+		if wait < min {
+			min = wait
+		}
+		if wait > max {
+			max = wait
+		}
+	}
+	return mean, min, max
+}
+
+func TestBackoffStrategy_distribution(t *testing.T) {
+	const samples = 20000
+	base, maxWait := 100*time.Millisecond, 10*time.Second
+
+	tests := []struct {
+		name     string
+		strategy BackoffStrategy
+		number   int
+		min, max time.Duration
+		mean     time.Duration
+	}{
+		// Retry n draws from [0, base*2^(n-1)].
+		{"full_jitter_first", NewFullJitter(base, maxWait), 2, 0, base, base / 2},
+		{"full_jitter_fourth", NewFullJitter(base, maxWait), 5, 0, 8 * base, 4 * base},
+		{"full_jitter_capped", NewFullJitter(base, maxWait), 20, 0, maxWait, maxWait / 2},
+		// Retry n draws from [base*2^(n-1)/2, base*2^(n-1)].
+		{"equal_jitter_first", NewEqualJitter(base, maxWait), 2, base / 2, base, 3 * base / 4},
+		{"equal_jitter_fourth", NewEqualJitter(base, maxWait), 5, 4 * base, 8 * base, 6 * base},
+		// The first retry draws from [base, 3*base].
+		{"decorrelated_jitter", NewDecorrelatedJitter(base, maxWait), 2, base, 3 * base, 2 * base},
+		{"constant_jitter", NewConstantJitter(time.Second, 200*time.Millisecond), 7, 800 * time.Millisecond, 1200 * time.Millisecond, time.Second},
+		{"constant_jitter_clamped", NewConstantJitter(100*time.Millisecond, time.Second), 2, 0, 1100 * time.Millisecond, 550 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mean, min, max := sampleBackoff(tt.strategy, tt.number, samples)
+			if min < tt.min || max > tt.max {
+				t.Fatalf("expected waits in [%s, %s], got [%s, %s]", tt.min, tt.max, min, max)
+			}
+			// The waits must cover their range rather than cluster.
+			spread := tt.max - tt.min
+			if min > tt.min+spread/20 || max < tt.max-spread/20 {
+				t.Fatalf("expected waits to cover [%s, %s], got [%s, %s]", tt.min, tt.max, min, max)
+			}
+			// With this many samples the mean of a uniform distribution is
+			// within 2% of the spread of the expected mean.
+			if diff := math.Abs(mean - float64(tt.mean)); diff > float64(spread)/50 {
+				t.Fatalf("expected a mean of %s, got %s", tt.mean, time.Duration(mean))
+			}
+		})
+	}
+}
+
+func TestBackoffStrategy_decorrelatedJitter(t *testing.T) {
+	base, maxWait := 100*time.Millisecond, 2*time.Second
+	strategy := NewDecorrelatedJitter(base, maxWait)
+	for run := 0; run < 100; run++ {
+		strategy.Reset()
+		prev := base
+		for number := 2; number < 20; number++ {
+			wait := strategy.Next(AttemptInfo{Number: number})
+			upper := 3 * prev
+			if upper > maxWait {
+				upper = maxWait
+			}
+			if wait < base || wait > upper {
+				t.Fatalf("expected a wait in [%s, %s], got %s", base, upper, wait)
+			}
+			prev = wait
+		}
+	}
+}
+
+func TestBackoffStrategy_fibonacci(t *testing.T) {
+	strategy := NewFibonacci(time.Second, 20*time.Second)
+	want := []time.Duration{1, 1, 2, 3, 5, 8, 13, 20, 20, 20}
+	for run := 0; run < 2; run++ {
+		for i, w := range want {
+			if got := strategy.Next(AttemptInfo{Number: i + 2}); got != w*time.Second {
+				t.Fatalf("retry %d: expected %s, got %s", i+1, w*time.Second, got)
+			}
+		}
+		strategy.Reset()
+	}
+
+	strategy = NewFibonacci(time.Second, time.Duration(math.MaxInt64))
+	for i := 0; i < 200; i++ {
+		if got := strategy.Next(AttemptInfo{Number: i + 2}); got < 0 {
+			t.Fatalf("retry %d: overflowed to %s", i+1, got)
+		}
+	}
+}
+
+func TestBackoffStrategy_retryAfter(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": []string{"7"}},
+	}
+	strategies := map[string]BackoffStrategy{
+		"full_jitter":         NewFullJitter(time.Millisecond, time.Second),
+		"equal_jitter":        NewEqualJitter(time.Millisecond, time.Second),
+		"decorrelated_jitter": NewDecorrelatedJitter(time.Millisecond, time.Second),
+		"fibonacci":           NewFibonacci(time.Millisecond, time.Second),
+		"constant_jitter":     NewConstantJitter(time.Millisecond, time.Millisecond),
+		"backoff_func":        BackoffFunc(DefaultBackoff, time.Millisecond, time.Second),
+	}
+	for name, strategy := range strategies {
+		if got := strategy.Next(AttemptInfo{Number: 2, PrevResponse: resp}); got != 7*time.Second {
+			t.Fatalf("%s: expected to honor Retry-After, got %s", name, got)
+		}
+	}
+}
+
+func TestBackoffFunc(t *testing.T) {
+	var gotMin, gotMax time.Duration
+	var gotAttempt int
+	var gotResp *http.Response
+	strategy := BackoffFunc(func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		gotMin, gotMax, gotAttempt, gotResp = min, max, attemptNum, resp
+		return time.Minute
+	}, time.Second, time.Hour)
+
+	resp := &http.Response{StatusCode: http.StatusBadGateway}
+	if wait := strategy.Next(AttemptInfo{Number: 3, PrevResponse: resp}); wait != time.Minute {
+		t.Fatalf("expected the wait of the Backoff, got %s", wait)
+	}
+	if gotMin != time.Second || gotMax != time.Hour || gotAttempt != 1 || gotResp != resp {
+		t.Fatalf("unexpected arguments %s, %s, %d, %v", gotMin, gotMax, gotAttempt, gotResp)
+	}
+}
+
+// recordingStrategy is a BackoffStrategy which records how it is used.
+type recordingStrategy struct {
+	resets   int
+	attempts []AttemptInfo
+}
+
+func (s *recordingStrategy) Next(attempt AttemptInfo) time.Duration {
+	s.attempts = append(s.attempts, attempt)
+	return time.Millisecond
+}
+
+func (s *recordingStrategy) Reset() {
+	s.resets++
+}
+
+func TestClient_NewBackoffStrategy(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	var strategies []*recordingStrategy
+	client := NewClient()
+	client.RetryMax = 3
+	client.Backoff = func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		t.Fatalf("expected the BackoffStrategy to be used instead of Backoff")
+		return 0
+	}
+	client.NewBackoffStrategy = func() BackoffStrategy {
+		s := &recordingStrategy{}
+		strategies = append(strategies, s)
+		return s
+	}
+
+	client.Get(ts.URL)
+	if len(strategies) != 1 {
+		t.Fatalf("expected one strategy per request, got %d", len(strategies))
+	}
+	s := strategies[0]
+	if s.resets != 1 || len(s.attempts) != 3 {
+		t.Fatalf("expected 1 reset and 3 waits, got %d and %d", s.resets, len(s.attempts))
+	}
+	for i, attempt := range s.attempts {
+		if attempt.Number != i+2 || attempt.PrevResponse == nil {
+			t.Fatalf("unexpected attempt %#v", attempt)
+		}
+	}
+	if s.attempts[0].PrevStatus != http.StatusServiceUnavailable || s.attempts[1].PrevResponse.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected the previous responses to be passed, got %#v", s.attempts)
+	}
+
+	// A Backoff set on the request wins.
+	var backoffs int
+	req := mustNewRequest(t, "GET", ts.URL)
+	req.SetRetryMax(1)
+	req.SetBackoff(func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		backoffs++
+		return time.Millisecond
+	})
+	client.Do(req)
+	if backoffs != 1 || len(strategies) != 1 {
+		t.Fatalf("expected the request's Backoff to be used, got %d calls and %d strategies", backoffs, len(strategies))
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of a circuit of a CircuitBreaker.
+type CircuitState int
+
+const (
+	// CircuitClosed lets every attempt through while counting failures.
+	CircuitClosed CircuitState = iota
+
+	// CircuitOpen rejects every attempt until the cool-down has elapsed.
+	CircuitOpen
+
+	// CircuitHalfOpen lets a limited number of probe attempts through to
+	// find out whether the upstream has recovered.
+	CircuitHalfOpen
+)
+
+// String returns the name of the state.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// CircuitBreaker stops a Client from sending attempts to an upstream which
+// keeps failing, instead of running the full retry schedule against it. It
+// keeps one circuit per key, by default the host of the request.
+//
+// A circuit starts closed. An attempt fails when the retry policy of the
+// client wants to retry it. Once failures reach one of the thresholds, the
+// circuit opens and attempts fail fast with a *CircuitOpenError. After
+// CoolDown, the circuit becomes half-open and lets HalfOpenProbes attempts
+// through: the circuit closes once they have all succeeded, and opens again
+// as soon as one fails.
+//
+// A CircuitBreaker is safe for concurrent use, and may be shared by several
+// clients. Its fields must not be changed once it is in use.
+type CircuitBreaker struct {
+	// Key returns the key of the circuit of a request. It defaults to the
+	// host of the request URL.
+	Key func(*http.Request) string
+
+	// ConsecutiveFailures, if positive, opens the circuit after that many
+	// failures in a row.
+	ConsecutiveFailures int
+
+	// FailureRate, if positive, opens the circuit once the failures among
+	// the last Window attempts make up at least that fraction of them.
+	FailureRate float64
+
+	// Window is the number of most recent attempts over which FailureRate
+	// is measured. The rate is only considered once that many attempts
+	// were made. It defaults to 20.
+	Window int
+
+	// CoolDown is how long the circuit stays open before letting probes
+	// through.
+	CoolDown time.Duration
+
+	// HalfOpenProbes is the number of attempts let through by a half-open
+	// circuit, all of which must succeed for it to close. It defaults to 1.
+	HalfOpenProbes int
+
+	// OnStateChange, if set, is called whenever a circuit changes state.
+	// It is called without any lock held, and the Client also logs the
+	// change.
+	OnStateChange func(key string, from, to CircuitState)
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// NewCircuitBreaker returns a CircuitBreaker which opens after 5
+// consecutive failures and probes again after 30 seconds.
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		ConsecutiveFailures: 5,
+		CoolDown:            30 * time.Second,
+		HalfOpenProbes:      1,
+	}
+}
+
+// State returns the state of the circuit with the given key. An open
+// circuit whose cool-down has elapsed only becomes half-open with the next
+// attempt.
+func (b *CircuitBreaker) State(key string) CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok := b.circuits[key]; ok {
+		return c.state
+	}
+	return CircuitClosed
+}
+
+// circuit is the state of one key of a CircuitBreaker.
+type circuit struct {
+	state CircuitState
+
+	// consecutive is the number of failures in a row, and outcomes the
+	// last Window outcomes as a ring buffer, true meaning a failure.
+	consecutive int
+	outcomes    []bool
+	next        int
+	full        bool
+
+	// openedUntil is when an open circuit next lets a probe through.
+	openedUntil time.Time
+
+	// probes is the number of probes let through by a half-open circuit,
+	// and succeeded how many of them succeeded.
+	probes    int
+	succeeded int
+}
+
+// circuitResult is the outcome of an attempt let through by a circuit.
+type circuitResult int
+
+const (
+	circuitSuccess circuitResult = iota
+	circuitFailure
+
+	// circuitIgnored is the outcome of an attempt which says nothing about
+	// the upstream, such as one canceled by the caller.
+	circuitIgnored
+)
+
+// circuitTransition is a change of state of a circuit, which the Client
+// logs.
+type circuitTransition struct {
+	key      string
+	from, to CircuitState
+}
+
+func (b *CircuitBreaker) key(req *http.Request) string {
+	if b.Key != nil {
+		return b.Key(req)
+	}
+	return req.URL.Host
+}
+
+func (b *CircuitBreaker) halfOpenProbes() int {
+	if b.HalfOpenProbes > 0 {
+		return b.HalfOpenProbes
+	}
+	return 1
+}
+
+func (b *CircuitBreaker) window() int {
+	if b.Window > 0 {
+		return b.Window
+	}
+	return 20
+}
+
+// circuit returns the circuit with the given key, creating it if needed.
+// The breaker must be locked.
+func (b *CircuitBreaker) circuit(key string) *circuit {
+	if b.circuits == nil {
+		b.circuits = make(map[string]*circuit)
+	}
+	c, ok := b.circuits[key]
+	if !ok {
+		c = &circuit{}
+		b.circuits[key] = c
+	}
+	return c
+}
+
+// allow lets an attempt through the circuit with the given key at now, or
+// returns a *CircuitOpenError. Every attempt let through must be followed by
+// a call to record.
+func (b *CircuitBreaker) allow(key string, now time.Time) (*circuitTransition, error) {
+	b.mu.Lock()
+	c := b.circuit(key)
+	t := b.expire(key, c, now)
+	var err error
+	switch c.state {
+	case CircuitOpen:
+		err = &CircuitOpenError{Key: key, RetryAt: c.openedUntil}
+	case CircuitHalfOpen:
+		if c.probes >= b.halfOpenProbes() {
+			// The probes are still in flight; try again once they are done.
+			err = &CircuitOpenError{Key: key, RetryAt: now}
+		} else {
+			c.probes++
+		}
+	}
+	b.mu.Unlock()
+
+	b.notify(t)
+	return t, err
+}
+
+// check is like allow, but doesn't let anything through. It tells whether
+// an attempt made at the given time would be let through.
+func (b *CircuitBreaker) check(key string, at time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c, ok := b.circuits[key]
+	if !ok {
+		return nil
+	}
+	switch c.state {
+	case CircuitOpen:
+		if at.Before(c.openedUntil) {
+			return &CircuitOpenError{Key: key, RetryAt: c.openedUntil}
+		}
+	case CircuitHalfOpen:
+		if c.probes >= b.halfOpenProbes() {
+			return &CircuitOpenError{Key: key, RetryAt: at}
+		}
+	}
+	return nil
+}
+
+// record records the result of an attempt let through by allow.
+func (b *CircuitBreaker) record(key string, result circuitResult, now time.Time) *circuitTransition {
+	b.mu.Lock()
+	c := b.circuit(key)
+	var t *circuitTransition
+	switch c.state {
+	case CircuitHalfOpen:
+		if c.probes > 0 {
+			c.probes--
+		}
+		switch result {
+		case circuitFailure:
+			t = b.open(key, c, now)
+		case circuitSuccess:
+			c.succeeded++
+			if c.succeeded >= b.halfOpenProbes() {
+				t = b.setState(key, c, CircuitClosed)
+			}
+		}
+	case CircuitClosed:
+		if result == circuitIgnored {
+			break
+		}
+		failed := result == circuitFailure
+		if failed {
+			c.consecutive++
+		} else {
+			c.consecutive = 0
+		}
+		if b.FailureRate > 0 {
+			if len(c.outcomes) != b.window() {
+				c.outcomes = make([]bool, b.window())
+			}
+			c.outcomes[c.next] = failed
+			c.next = (c.next + 1) % len(c.outcomes)
+			if c.next == 0 {
+				c.full = true
+			}
+		}
+		if b.tripped(c) {
+			t = b.open(key, c, now)
+		}
+	}
+	b.mu.Unlock()
+
+	b.notify(t)
+	return t
+}
+
+// tripped reports whether a closed circuit has reached a threshold.
+func (b *CircuitBreaker) tripped(c *circuit) bool {
+	if b.ConsecutiveFailures > 0 && c.consecutive >= b.ConsecutiveFailures {
+		return true
+	}
+	if b.FailureRate > 0 && c.full {
+		failures := 0
+		for _, failed := range c.outcomes {
+			if failed {
+				failures++
+			}
+		}
+		return float64(failures)/float64(len(c.outcomes)) >= b.FailureRate
+	}
+	return false
+}
+
+// open opens the circuit until the cool-down has elapsed.
+func (b *CircuitBreaker) open(key string, c *circuit, now time.Time) *circuitTransition {
+	c.openedUntil = now.Add(b.CoolDown)
+	return b.setState(key, c, CircuitOpen)
+}
+
+// expire turns an open circuit whose cool-down has elapsed half-open.
+func (b *CircuitBreaker) expire(key string, c *circuit, now time.Time) *circuitTransition {
+	if c.state != CircuitOpen || now.Before(c.openedUntil) {
+		return nil
+	}
+	return b.setState(key, c, CircuitHalfOpen)
+}
+
+// setState moves the circuit to the given state, starting its counts
+// afresh.
+func (b *CircuitBreaker) setState(key string, c *circuit, to CircuitState) *circuitTransition {
+	from := c.state
+	c.state = to
+	c.consecutive, c.next, c.full = 0, 0, false
+	c.outcomes = nil
+	c.probes, c.succeeded = 0, 0
+	return &circuitTransition{key: key, from: from, to: to}
+}
+
+func (b *CircuitBreaker) notify(t *circuitTransition) {
+	if t != nil && b.OnStateChange != nil {
+		b.OnStateChange(t.key, t.from, t.to)
+	}
+}
+
+// logCircuitTransition logs a change of state of a circuit of the client's
+// CircuitBreaker.
+func (c *Client) logCircuitTransition(logger interface{}, t *circuitTransition) {
+	if t == nil || logger == nil {
+		return
+	}
+	switch v := logger.(type) {
+	case LeveledLogger:
+		v.Warn("circuit state changed", "key", t.key, "from", t.from, "to", t.to)
+	case Logger:
+		v.Printf("[WARN] circuit %s changed from %s to %s", t.key, t.from, t.to)
+	}
+}
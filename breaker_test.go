@@ -0,0 +1,310 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var changes []string
+	b := &CircuitBreaker{
+		ConsecutiveFailures: 2,
+		CoolDown:            time.Minute,
+		HalfOpenProbes:      2,
+		OnStateChange: func(key string, from, to CircuitState) {
+			changes = append(changes, key+": "+from.String()+" -> "+to.String())
+		},
+	}
+
+	pass := func() {
+		t.Helper()
+		if _, err := b.allow("a", now); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+	reject := func(retryAt time.Time) {
+		t.Helper()
+		_, err := b.allow("a", now)
+		var openErr *CircuitOpenError
+		if !errors.As(err, &openErr) || !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("expected a *CircuitOpenError, got %v", err)
+		}
+		if openErr.Key != "a" || !openErr.RetryAt.Equal(retryAt) {
+			t.Fatalf("unexpected error %#v", openErr)
+		}
+	}
+
+	// A success resets the count of consecutive failures.
+	pass()
+	b.record("a", circuitFailure, now)
+	pass()
+	b.record("a", circuitSuccess, now)
+	pass()
+	b.record("a", circuitFailure, now)
+	pass()
+	b.record("a", circuitIgnored, now)
+	if got := b.State("a"); got != CircuitClosed {
+		t.Fatalf("expected the circuit to be closed, got %s", got)
+	}
+	pass()
+	b.record("a", circuitFailure, now)
+	if got := b.State("a"); got != CircuitOpen {
+		t.Fatalf("expected the circuit to be open, got %s", got)
+	}
+	reject(now.Add(time.Minute))
+
+	// Other circuits are unaffected.
+	if _, err := b.allow("b", now); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Once the cool-down has elapsed, the probes are let through, but not
+	// more.
+	if _, err := b.allow("a", now.Add(time.Minute-time.Second)); err == nil {
+		t.Fatalf("expected an attempt before the end of the cool-down to be rejected")
+	}
+	now = now.Add(time.Minute)
+	pass()
+	pass()
+	reject(now)
+
+	// A failed probe opens the circuit again.
+	b.record("a", circuitSuccess, now)
+	b.record("a", circuitFailure, now)
+	reject(now.Add(time.Minute))
+
+	// Successful probes close it.
+	now = now.Add(time.Minute)
+	pass()
+	b.record("a", circuitSuccess, now)
+	pass()
+	b.record("a", circuitSuccess, now)
+	if got := b.State("a"); got != CircuitClosed {
+		t.Fatalf("expected the circuit to be closed, got %s", got)
+	}
+
+	want := []string{
+		"a: closed -> open",
+		"a: open -> half-open",
+		"a: half-open -> open",
+		"a: open -> half-open",
+		"a: half-open -> closed",
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Fatalf("expected state changes %v, got %v", want, changes)
+	}
+}
+
+func TestCircuitBreaker_failureRate(t *testing.T) {
+	now := time.Now()
+	b := &CircuitBreaker{FailureRate: 0.5, Window: 4, CoolDown: time.Minute}
+
+	for _, result := range []circuitResult{circuitFailure, circuitSuccess, circuitFailure} {
+		b.allow("a", now)
+		b.record("a", result, now)
+	}
+	// The rate isn't considered until the window is full.
+	if got := b.State("a"); got != CircuitClosed {
+		t.Fatalf("expected the circuit to be closed, got %s", got)
+	}
+	b.allow("a", now)
+	b.record("a", circuitSuccess, now)
+	if got := b.State("a"); got != CircuitOpen {
+		t.Fatalf("expected 2 failures out of 4 to open the circuit, got %s", got)
+	}
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	var healthy, requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.SetAutoAdvance(true)
+
+	buf := new(bytes.Buffer)
+	client := NewClient()
+	client.Logger = log.New(buf, "", log.Lshortfile)
+	client.Clock = clock
+	client.RetryMax = 10
+	client.RetryWaitMin = time.Second
+	client.RetryWaitMax = time.Second
+	client.CircuitBreaker = &CircuitBreaker{ConsecutiveFailures: 3, CoolDown: time.Minute}
+
+	// The retries stop once the circuit opens.
+	_, err := client.Get(ts.URL)
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected a *CircuitOpenError, got %v", err)
+	}
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || len(retryErr.Attempts) != 3 {
+		t.Fatalf("expected a RetryError after 3 attempts, got %v", err)
+	}
+	if want := clock.Now().Add(time.Minute); !openErr.RetryAt.Equal(want) {
+		t.Fatalf("expected a probe to be allowed at %s, got %s", want, openErr.RetryAt)
+	}
+	if got := atomic.LoadInt32(&requests); got != 3 {
+		t.Fatalf("expected 3 requests, got %d", got)
+	}
+
+	// Further requests fail fast.
+	_, info, err := client.DoWithInfo(mustNewRequest(t, "GET", ts.URL))
+	if !errors.As(err, &openErr) || len(info.Attempts) != 0 {
+		t.Fatalf("expected the request to fail fast, got %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 3 {
+		t.Fatalf("expected 3 requests, got %d", got)
+	}
+
+	// Once the cool-down has elapsed, a successful probe closes the circuit.
+	atomic.StoreInt32(&healthy, 1)
+	clock.Advance(time.Minute)
+	if _, err := client.Get(ts.URL); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := client.CircuitBreaker.State(strings.TrimPrefix(ts.URL, "http://")); got != CircuitClosed {
+		t.Fatalf("expected the circuit to be closed, got %s", got)
+	}
+
+	for _, want := range []string{"changed from closed to open", "changed from open to half-open", "changed from half-open to closed"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("expected the log to contain %q, got:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestClient_CircuitBreaker_retry(t *testing.T) {
+	errDown := errors.New("upstream down")
+	var requests int32
+	client := NewClient()
+	client.RetryMax = 10
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.CircuitBreaker = &CircuitBreaker{ConsecutiveFailures: 1, CoolDown: time.Minute}
+	client.HTTPClient.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&requests, 1)
+		// Another request opens the circuit while this one is in flight,
+		// so that its retry is rejected.
+		client.CircuitBreaker.record(req.URL.Host, circuitFailure, time.Now())
+		if req.Header.Get("X-Fail") != "" {
+			return nil, errDown
+		}
+		return &http.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Status:     "503 Service Unavailable",
+			Body:       io.NopCloser(strings.NewReader("unavailable")),
+			Request:    req,
+		}, nil
+	})
+
+	// A rejected retry keeps the error of the last attempt.
+	req := mustNewRequest(t, "GET", "http://example.com")
+	req.Header.Set("X-Fail", "1")
+	_, err := client.Do(req)
+	if !errors.Is(err, ErrCircuitOpen) || !errors.Is(err, errDown) {
+		t.Fatalf("expected the retry to be rejected after the attempt failed, got %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Fatalf("expected 1 request, got %d", got)
+	}
+
+	// And its response, which is still readable.
+	client.CircuitBreaker = &CircuitBreaker{ConsecutiveFailures: 1, CoolDown: time.Minute}
+	client.ErrorHandler = PassthroughErrorHandler
+	resp, err := client.Get("http://example.com")
+	if err == nil || resp == nil {
+		t.Fatalf("expected the response and error of the last attempt, got %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "unavailable" {
+		t.Fatalf("expected the body of the last attempt, got %q, %v", body, err)
+	}
+}
+
+func TestClient_CircuitBreaker_probe(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.SetAutoAdvance(true)
+
+	errDown := errors.New("upstream down")
+	var requests int32
+	client := NewClient()
+	client.Clock = clock
+	client.RetryMax = 1
+	client.RetryWaitMin = time.Minute
+	client.RetryWaitMax = time.Minute
+	client.CircuitBreaker = &CircuitBreaker{ConsecutiveFailures: 1, CoolDown: time.Minute}
+	client.HTTPClient.Transport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if atomic.AddInt32(&requests, 1) == 1 && req.Header.Get("X-Fail") != "" {
+			return nil, errDown
+		}
+		return &http.Response{
+			StatusCode: http.StatusServiceUnavailable,
+			Status:     "503 Service Unavailable",
+			Body:       io.NopCloser(strings.NewReader("unavailable")),
+			Request:    req,
+		}, nil
+	})
+
+	// Waiting for a retry at the end of the cool-down doesn't turn the
+	// circuit half-open early, but another request takes the probe first.
+	var state CircuitState
+	client.PrepareRetry = func(*http.Request) error {
+		state = client.CircuitBreaker.State("example.com")
+		if _, err := client.CircuitBreaker.allow("example.com", clock.Now()); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return nil
+	}
+	req := mustNewRequest(t, "GET", "http://example.com")
+	req.Header.Set("X-Fail", "1")
+	_, err := client.Do(req)
+	var openErr *CircuitOpenError
+	if !errors.As(err, &openErr) || !errors.Is(err, errDown) {
+		t.Fatalf("expected the retry to be rejected after the attempt failed, got %v", err)
+	}
+	if state != CircuitOpen {
+		t.Fatalf("expected the circuit to be open during the wait, got %s", state)
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Fatalf("expected 1 request, got %d", got)
+	}
+
+	// The response of the last attempt is still readable.
+	client.CircuitBreaker = &CircuitBreaker{ConsecutiveFailures: 1, CoolDown: time.Minute}
+	client.ErrorHandler = PassthroughErrorHandler
+	client.PrepareRetry = func(*http.Request) error {
+		client.CircuitBreaker.allow("example.com", clock.Now())
+		return nil
+	}
+	resp, err := client.Get("http://example.com")
+	if err == nil || resp == nil {
+		t.Fatalf("expected the response and error of the last attempt, got %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "unavailable" {
+		t.Fatalf("expected the body of the last attempt, got %q, %v", body, err)
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RetryBudget limits the retries made by a Client across all of its
+// requests, so that retries can't multiply the load on an upstream which is
+// already struggling. Unlike RetryMax, which bounds the retries of each
+// request, a budget bounds them in proportion to the traffic.
+//
+// The requests passed to the methods are those of the attempts, whose
+// context is that of the attempt; see AttemptFromContext. Implementations
+// must be safe for concurrent use.
+type RetryBudget interface {
+	// Deposit is called once per request, before its first attempt.
+	Deposit(req *http.Request)
+
+	// Withdraw is called before every retry, with the request, response
+	// and error of the attempt which failed. It reports whether the budget
+	// allows the retry, in which case the retry is paid for.
+	Withdraw(req *http.Request, resp *http.Response, err error) bool
+
+	// Refund is called once a request succeeds, with the request of the
+	// attempt which succeeded.
+	Refund(req *http.Request)
+}
+
+// RatioRetryBudget is a RetryBudget allowing retries to make up at most a
+// fraction of the requests, plus a fixed number per second so that clients
+// with little traffic can still retry. For example, a Ratio of 0.1 and a
+// MinPerSecond of 10 allow retries to be at most 10% of requests plus 10 per
+// second. Requests and retries are counted over a sliding window of TTL.
+type RatioRetryBudget struct {
+	// Ratio is the number of retries allowed per request.
+	Ratio float64
+
+	// MinPerSecond is the number of retries allowed per second regardless
+	// of the number of requests.
+	MinPerSecond float64
+
+	// TTL is how long requests and retries count towards the budget. It
+	// defaults to 10 seconds.
+	TTL time.Duration
+
+	// Key, if set, returns the key of the budget of a request, such as its
+	// host, so that each key has a budget of its own. By default, a single
+	// budget is shared by all requests.
+	Key func(*http.Request) string
+
+	mu      sync.Mutex
+	windows map[string]*slidingWindow
+}
+
+// NewRetryBudget returns a RatioRetryBudget allowing retries to be at most
+// the given fraction of requests plus minPerSecond per second.
+func NewRetryBudget(ratio, minPerSecond float64) *RatioRetryBudget {
+	return &RatioRetryBudget{Ratio: ratio, MinPerSecond: minPerSecond}
+}
+
+// windowSlots is the number of slots of a slidingWindow.
+const windowSlots = 10
+
+// slidingWindow counts two kinds of events over a sliding window of time,
+// divided in slots. Events older than the window stop counting as the slots
+// are reused.
+type slidingWindow struct {
+	slots [windowSlots]windowSlot
+}
+
+type windowSlot struct {
+	// epoch identifies the period the slot counts, since slots are reused.
+	epoch  int64
+	counts [2]int
+}
+
+// windowEpoch returns the period of a slot of a window of the given size which
+// contains now. Periods before the Unix epoch are negative.
+func windowEpoch(now time.Time, size time.Duration) int64 {
+	width := int64(size / windowSlots)
+	if width <= 0 {
+		width = 1
+	}
+	ns := now.UnixNano()
+	epoch := ns / width
+	if ns%width < 0 {
+		epoch--
+	}
+	return epoch
+}
+
+// add counts an event of the given kind at now.
+func (w *slidingWindow) add(now time.Time, size time.Duration, kind int) {
+	epoch := windowEpoch(now, size)
+	s := &w.slots[(epoch%windowSlots+windowSlots)%windowSlots]
+	if s.epoch != epoch {
+		*s = windowSlot{epoch: epoch}
+	}
+	s.counts[kind]++
+}
+
+// sums returns the number of events of each kind in the window ending at
+// now.
+func (w *slidingWindow) sums(now time.Time, size time.Duration) (int, int) {
+	epoch := windowEpoch(now, size)
+	var a, b int
+	for _, s := range w.slots {
+		if epoch-s.epoch < windowSlots {
+			a += s.counts[0]
+			b += s.counts[1]
+		}
+	}
+	return a, b
+}
+
+// The kinds of events counted by a RatioRetryBudget.
+const (
+	budgetRequests = iota
+	budgetRetries
+)
+
+func (b *RatioRetryBudget) ttl() time.Duration {
+	if b.TTL > 0 {
+		return b.TTL
+	}
+	return 10 * time.Second
+}
+
+// window returns the window of req's budget. The budget must be locked.
+func (b *RatioRetryBudget) window(req *http.Request) *slidingWindow {
+	key := ""
+	if b.Key != nil {
+		key = b.Key(req)
+	}
+	if b.windows == nil {
+		b.windows = make(map[string]*slidingWindow)
+	}
+	w, ok := b.windows[key]
+	if !ok {
+		w = &slidingWindow{}
+		b.windows[key] = w
+	}
+	return w
+}
+
+// Deposit counts a request.
+func (b *RatioRetryBudget) Deposit(req *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.window(req).add(clockFromContext(req.Context()).Now(), b.ttl(), budgetRequests)
+}
+
+// Withdraw counts a retry if the retries made over the TTL remain within
+// the budget.
+func (b *RatioRetryBudget) Withdraw(req *http.Request, _ *http.Response, _ error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w := b.window(req)
+	now := clockFromContext(req.Context()).Now()
+
+	requests, retries := w.sums(now, b.ttl())
+	allowed := b.Ratio*float64(requests) + b.MinPerSecond*b.ttl().Seconds()
+	if float64(retries+1) > allowed {
+		return false
+	}
+	w.add(now, b.ttl(), budgetRetries)
+	return true
+}
+
+// Refund does nothing, since a RatioRetryBudget only counts requests and
+// retries.
+func (b *RatioRetryBudget) Refund(*http.Request) {}
+
+// RetryQuota is a RetryBudget in the style of a token bucket, where each
+// retry costs tokens depending on why the attempt failed, and successful
+// requests earn tokens back. Retrying after a timeout costs TimeoutCost
+// tokens, and retrying after any other failure FailureCost tokens. A request
+// which succeeds at once earns SuccessReward tokens, and one which succeeds
+// after retries is refunded the cost of its last retry.
+type RetryQuota struct {
+	// Capacity is the number of tokens the quota starts with, and the
+	// most it can hold.
+	Capacity float64
+
+	// FailureCost and TimeoutCost are the costs of a retry after a failed
+	// attempt and after one which timed out.
+	FailureCost float64
+	TimeoutCost float64
+
+	// SuccessReward is the number of tokens earned by a request which
+	// succeeds on its first attempt.
+	SuccessReward float64
+
+	// Key, if set, returns the key of the quota of a request, such as its
+	// host, so that each key has a quota of its own. By default, a single
+	// quota is shared by all requests.
+	Key func(*http.Request) string
+
+	mu     sync.Mutex
+	tokens map[string]float64
+}
+
+// NewRetryQuota returns a RetryQuota holding 500 tokens, where retries cost
+// 10 tokens after a failure and 5 after a timeout, and successes earn 1.
+func NewRetryQuota() *RetryQuota {
+	return &RetryQuota{
+		Capacity:      500,
+		FailureCost:   10,
+		TimeoutCost:   5,
+		SuccessReward: 1,
+	}
+}
+
+func (q *RetryQuota) key(req *http.Request) string {
+	if q.Key != nil {
+		return q.Key(req)
+	}
+	return ""
+}
+
+// balance returns the tokens of the quota with the given key. The quota
+// must be locked.
+func (q *RetryQuota) balance(key string) float64 {
+	if q.tokens == nil {
+		q.tokens = make(map[string]float64)
+	}
+	tokens, ok := q.tokens[key]
+	if !ok {
+		tokens = q.Capacity
+		q.tokens[key] = tokens
+	}
+	return tokens
+}
+
+// Deposit does nothing, since a RetryQuota earns tokens from successes.
+func (q *RetryQuota) Deposit(*http.Request) {}
+
+// Withdraw pays for a retry if enough tokens are left.
+func (q *RetryQuota) Withdraw(req *http.Request, _ *http.Response, err error) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := q.key(req)
+	cost := q.cost(err)
+	tokens := q.balance(key)
+	if tokens < cost {
+		return false
+	}
+	q.tokens[key] = tokens - cost
+	return true
+}
+
+// Refund earns tokens for a successful request.
+func (q *RetryQuota) Refund(req *http.Request) {
+	reward := q.SuccessReward
+	if attempt, ok := AttemptFromContext(req.Context()); ok && attempt.Number > 1 {
+		reward = q.cost(attempt.PrevErr)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	key := q.key(req)
+	q.tokens[key] = math.Min(q.balance(key)+reward, q.Capacity)
+}
+
+// cost returns the cost of retrying an attempt which failed with err.
+func (q *RetryQuota) cost(err error) float64 {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return q.TimeoutCost
+	}
+	return q.FailureCost
+}
@@ -0,0 +1,189 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// budgetRequest returns a request of an attempt made by a client with the
+// given clock.
+func budgetRequest(t *testing.T, clock Clock, host string, attempt AttemptInfo) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("GET", "http://"+host+"/", nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return req.WithContext(newAttemptContext(req.Context(), req, attempt, &Client{Clock: clock}))
+}
+
+func TestRatioRetryBudget(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	b := NewRetryBudget(0.5, 0)
+	b.Key = func(req *http.Request) string { return req.URL.Host }
+	a := budgetRequest(t, clock, "a", AttemptInfo{Number: 1})
+
+	for i := 0; i < 4; i++ {
+		b.Deposit(a)
+	}
+	for i := 0; i < 2; i++ {
+		if !b.Withdraw(a, nil, nil) {
+			t.Fatalf("expected retry %d to be allowed", i+1)
+		}
+	}
+	if b.Withdraw(a, nil, nil) {
+		t.Fatalf("expected the budget to be exhausted")
+	}
+
+	// Each key has a budget of its own.
+	other := budgetRequest(t, clock, "b", AttemptInfo{Number: 1})
+	b.Deposit(other)
+	b.Deposit(other)
+	if !b.Withdraw(other, nil, nil) {
+		t.Fatalf("expected the other budget to allow a retry")
+	}
+
+	// Requests and retries stop counting after the TTL.
+	clock.Advance(5 * time.Second)
+	b.Deposit(a)
+	b.Deposit(a)
+	if !b.Withdraw(a, nil, nil) || b.Withdraw(a, nil, nil) {
+		t.Fatalf("expected the new requests to allow one retry")
+	}
+	clock.Advance(5 * time.Second)
+	b.Deposit(a)
+	b.Deposit(a)
+	if !b.Withdraw(a, nil, nil) || b.Withdraw(a, nil, nil) {
+		t.Fatalf("expected the requests of the last 10s to allow one more retry")
+	}
+
+	// MinPerSecond allows retries without requests.
+	b.MinPerSecond = 0.2
+	clock.Advance(time.Minute)
+	for i := 0; i < 2; i++ {
+		if !b.Withdraw(a, nil, nil) {
+			t.Fatalf("expected retry %d to be allowed", i+1)
+		}
+	}
+	if b.Withdraw(a, nil, nil) {
+		t.Fatalf("expected the budget to be exhausted")
+	}
+}
+
+func TestRetryQuota(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	q := NewRetryQuota()
+	q.Capacity = 20
+	req := budgetRequest(t, clock, "a", AttemptInfo{Number: 1})
+	failure := errors.New("connection reset")
+
+	if !q.Withdraw(req, nil, failure) || !q.Withdraw(req, nil, context.DeadlineExceeded) {
+		t.Fatalf("expected the retries to be allowed")
+	}
+	// 5 tokens are left, which pay for a timeout but not a failure.
+	if q.Withdraw(req, nil, failure) {
+		t.Fatalf("expected a failure to cost more than what is left")
+	}
+	if q.Withdraw(req, &http.Response{StatusCode: http.StatusServiceUnavailable}, nil) {
+		t.Fatalf("expected an error response to cost as much as a failure")
+	}
+	if !q.Withdraw(req, nil, ErrAttemptTimeout) {
+		t.Fatalf("expected an attempt timeout to cost less than a failure")
+	}
+	if q.Withdraw(req, nil, context.DeadlineExceeded) {
+		t.Fatalf("expected the quota to be exhausted")
+	}
+
+	// A success at the first attempt earns a token, one after a retry the
+	// cost of the retry.
+	q.Refund(req)
+	q.Refund(budgetRequest(t, clock, "a", AttemptInfo{Number: 2, PrevErr: context.DeadlineExceeded}))
+	if !q.Withdraw(req, nil, context.DeadlineExceeded) || q.Withdraw(req, nil, context.DeadlineExceeded) {
+		t.Fatalf("expected 6 tokens to be refunded")
+	}
+
+	// The quota never exceeds its capacity.
+	for i := 0; i < 100; i++ {
+		q.Refund(req)
+	}
+	if !q.Withdraw(req, nil, failure) || !q.Withdraw(req, nil, failure) || q.Withdraw(req, nil, context.DeadlineExceeded) {
+		t.Fatalf("expected the quota to be capped at its capacity")
+	}
+}
+
+func TestClient_RetryBudget(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.SetAutoAdvance(true)
+
+	client := NewClient()
+	client.Clock = clock
+	client.RetryMax = 5
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	// Two retries over 10 seconds.
+	client.RetryBudget = NewRetryBudget(0, 0.2)
+
+	_, err := client.Get(ts.URL)
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Fatalf("expected the budget to be exhausted, got %v", err)
+	}
+	if len(retryErr.Attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(retryErr.Attempts))
+	}
+	if !strings.Contains(err.Error(), "retry budget exhausted") {
+		t.Fatalf("expected the error to give the reason, got %v", err)
+	}
+
+	// The last result is returned to an ErrorHandler.
+	client.ErrorHandler = PassthroughErrorHandler
+	resp, info, err := client.DoWithInfo(mustNewRequest(t, "GET", ts.URL))
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the last response, got %v", err)
+	}
+	resp.Body.Close()
+	if len(info.Attempts) != 1 {
+		t.Fatalf("expected a single attempt without retries, got %d", len(info.Attempts))
+	}
+}
+
+func TestClient_RetryBudget_zeroTime(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	// Windows reaching before the Unix epoch have negative periods.
+	clock := NewFakeClock(time.Time{})
+	clock.SetAutoAdvance(true)
+
+	client := NewClient()
+	client.Clock = clock
+	client.RetryMax = 5
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.Rand = fixedRand(0.99)
+	client.RetryBudget = NewRetryBudget(0, 0.2)
+	client.Throttle = NewAdaptiveThrottle()
+
+	_, err := client.Get(ts.URL)
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Fatalf("expected the budget to be exhausted, got %v", err)
+	}
+	if len(retryErr.Attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(retryErr.Attempts))
+	}
+}
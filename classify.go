@@ -0,0 +1,212 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"syscall"
+)
+
+var (
+	// A regular expression to match the error returned by net/http when the
+	// configured number of redirects is exhausted. This error isn't typed
+	// specifically so we resort to matching on the error string.
+	redirectsErrorRe = regexp.MustCompile(`stopped after \d+ redirects\z`)
+
+	// A regular expression to match the error returned by net/http when the
+	// scheme specified in the URL is invalid. This error isn't typed
+	// specifically so we resort to matching on the error string.
+	schemeErrorRe = regexp.MustCompile(`unsupported protocol scheme`)
+
+	// A regular expression to match the error returned by net/http when a
+	// request header or value is invalid. This error isn't typed
+	// specifically so we resort to matching on the error string.
+	invalidHeaderErrorRe = regexp.MustCompile(`invalid header`)
+
+	// A regular expression to match the error returned by net/http when the
+	// TLS certificate is not trusted. This error isn't typed
+	// specifically so we resort to matching on the error string.
+	notTrustedErrorRe = regexp.MustCompile(`certificate is not trusted`)
+
+	// A regular expression to match HTTP/2 stream errors. The http2 package
+	// bundled with net/http does not export its error types, so we resort to
+	// matching on the error string to recover the error code.
+	http2StreamErrorRe = regexp.MustCompile(`stream error: stream ID \d+; ([A-Z_]+)`)
+
+	// A regular expression to match the error returned by net/http when the
+	// server sent a GOAWAY frame. This error isn't exported either.
+	http2GoAwayErrorRe = regexp.MustCompile(`server sent GOAWAY and closed the connection`)
+
+	// A regular expression to match the error returned by net/http when an
+	// HTTPS request was answered by a plaintext HTTP server. net/http replaces
+	// the underlying tls.RecordHeaderError with an untyped error.
+	plaintextServerErrorRe = regexp.MustCompile(`server gave HTTP response to HTTPS client`)
+)
+
+// ErrorClass describes how an error returned by the HTTP client should be
+// treated by a retry policy.
+type ErrorClass int
+
+const (
+	// ErrorClassAmbiguous errors may have happened after the server received
+	// the request, so a retry may repeat work the server already did. It is
+	// the class of any error that isn't otherwise recognized.
+	ErrorClassAmbiguous ErrorClass = iota
+
+	// ErrorClassTransient errors happened before the request reached the
+	// server and are expected to go away on their own, so the request is
+	// safe to retry.
+	ErrorClassTransient
+
+	// ErrorClassThrottled errors mean the server asked the client to slow
+	// down. The request may be retried after backing off.
+	ErrorClassThrottled
+
+	// ErrorClassPermanent errors will not go away by retrying, such as a
+	// host that does not exist or a certificate that is not trusted.
+	ErrorClassPermanent
+)
+
+// String returns the name of the class.
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorClassAmbiguous:
+		return "ambiguous"
+	case ErrorClassTransient:
+		return "transient"
+	case ErrorClassThrottled:
+		return "throttled"
+	case ErrorClassPermanent:
+		return "permanent"
+	default:
+		return "unknown"
+	}
+}
+
+// ErrorClassifier assigns an ErrorClass to a non-nil error returned by the
+// HTTP client. Custom CheckRetry functions can use it to share the
+// classification with the default policies.
+type ErrorClassifier func(err error) ErrorClass
+
+// DefaultErrorClassifier is the ErrorClassifier used by DefaultRetryPolicy
+// and ErrorPropagatedRetryPolicy. It inspects the error chain with errors.As
+// where the standard library exposes typed errors, and falls back to matching
+// the error string where it does not.
+func DefaultErrorClassifier(err error) ErrorClass {
+	// The caller gave up on the request; trying again won't help.
+	if errors.Is(err, context.Canceled) {
+		return ErrorClassPermanent
+	}
+
+	// A host which doesn't exist won't start existing by retrying, but
+	// other DNS failures such as timeouts happen before anything is sent.
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsNotFound {
+			return ErrorClassPermanent
+		}
+		return ErrorClassTransient
+	}
+
+	// Don't retry if the error was due to TLS cert verification failure.
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certInvalidErr x509.CertificateInvalidError
+	if errors.As(err, &unknownAuthorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &certInvalidErr) {
+		return ErrorClassPermanent
+	}
+	if v, ok := err.(*url.Error); ok && isCertError(v.Err) {
+		return ErrorClassPermanent
+	}
+
+	// A TLS handshake with something that doesn't speak TLS won't succeed
+	// on a retry either.
+	var recordHeaderErr tls.RecordHeaderError
+	if errors.As(err, &recordHeaderErr) {
+		return ErrorClassPermanent
+	}
+
+	// A refused connection means nothing was sent, while a reset or broken
+	// connection may have happened after the server received the request.
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrorClassTransient
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return ErrorClassAmbiguous
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return ErrorClassTransient
+	}
+
+	if m := http2StreamErrorRe.FindStringSubmatch(err.Error()); m != nil {
+		switch m[1] {
+		case "REFUSED_STREAM":
+			// The server guarantees that it did not process the stream.
+			return ErrorClassTransient
+		case "ENHANCE_YOUR_CALM":
+			return ErrorClassThrottled
+		case "PROTOCOL_ERROR", "FRAME_SIZE_ERROR", "COMPRESSION_ERROR", "HTTP_1_1_REQUIRED":
+			return ErrorClassPermanent
+		default:
+			return ErrorClassAmbiguous
+		}
+	}
+	if http2GoAwayErrorRe.MatchString(err.Error()) {
+		return ErrorClassAmbiguous
+	}
+
+	if v, ok := err.(*url.Error); ok {
+		// Don't retry if the error was due to too many redirects.
+		if redirectsErrorRe.MatchString(v.Error()) {
+			return ErrorClassPermanent
+		}
+
+		// Don't retry if the error was due to an invalid protocol scheme.
+		if schemeErrorRe.MatchString(v.Error()) {
+			return ErrorClassPermanent
+		}
+
+		// Don't retry if the error was due to an invalid header.
+		if invalidHeaderErrorRe.MatchString(v.Error()) {
+			return ErrorClassPermanent
+		}
+
+		// Don't retry if the error was due to TLS cert verification failure.
+		if notTrustedErrorRe.MatchString(v.Error()) {
+			return ErrorClassPermanent
+		}
+
+		// Don't retry if a plaintext server answered an HTTPS request.
+		if plaintextServerErrorRe.MatchString(v.Error()) {
+			return ErrorClassPermanent
+		}
+	}
+
+	return ErrorClassAmbiguous
+}
+
+// ClassifyingRetryPolicy returns a CheckRetry which behaves like
+// DefaultRetryPolicy, except that errors returned by the HTTP client are
+// classified with the given ErrorClassifier. Errors classified as
+// ErrorClassPermanent are not retried; all other classes are.
+func ClassifyingRetryPolicy(classify ErrorClassifier) CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		// do not retry on context.Canceled or context.DeadlineExceeded
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+
+		// don't propagate other errors
+		shouldRetry, _ := baseRetryPolicy(resp, err, classify)
+		return shouldRetry, nil
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDefaultErrorClassifier(t *testing.T) {
+	urlErr := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://example.com", Err: err}
+	}
+	opErr := func(op string, err error) error {
+		return &net.OpError{Op: op, Net: "tcp", Err: err}
+	}
+
+	tests := []struct {
+		name  string
+		err   error
+		class ErrorClass
+	}{
+		{"canceled", urlErr(context.Canceled), ErrorClassPermanent},
+		{"deadline", urlErr(context.DeadlineExceeded), ErrorClassAmbiguous},
+		{"nxdomain", urlErr(opErr("dial", &net.DNSError{Err: "no such host", IsNotFound: true})), ErrorClassPermanent},
+		{"dns_timeout", urlErr(opErr("dial", &net.DNSError{Err: "i/o timeout", IsTimeout: true})), ErrorClassTransient},
+		{"unknown_authority", urlErr(x509.UnknownAuthorityError{}), ErrorClassPermanent},
+		{"hostname", urlErr(x509.HostnameError{Host: "example.com"}), ErrorClassPermanent},
+		{"cert_verification", urlErr(&tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}), ErrorClassPermanent},
+		{"record_header", urlErr(tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}), ErrorClassPermanent},
+		{"connection_refused", urlErr(opErr("dial", os.NewSyscallError("connect", syscall.ECONNREFUSED))), ErrorClassTransient},
+		{"connection_reset", urlErr(opErr("read", os.NewSyscallError("read", syscall.ECONNRESET))), ErrorClassAmbiguous},
+		{"broken_pipe", urlErr(opErr("write", os.NewSyscallError("write", syscall.EPIPE))), ErrorClassAmbiguous},
+		{"dial_other", urlErr(opErr("dial", errors.New("no route"))), ErrorClassTransient},
+		{"http2_refused_stream", urlErr(errors.New("stream error: stream ID 3; REFUSED_STREAM")), ErrorClassTransient},
+		{"http2_enhance_your_calm", urlErr(errors.New("stream error: stream ID 3; ENHANCE_YOUR_CALM")), ErrorClassThrottled},
+		{"http2_internal_error", urlErr(errors.New("stream error: stream ID 3; INTERNAL_ERROR; received from peer")), ErrorClassAmbiguous},
+		{"http2_protocol_error", urlErr(errors.New("stream error: stream ID 3; PROTOCOL_ERROR")), ErrorClassPermanent},
+		{"http2_goaway", urlErr(errors.New("http2: server sent GOAWAY and closed the connection; LastStreamID=1, ErrCode=NO_ERROR, debug=\"\"")), ErrorClassAmbiguous},
+		{"redirects", urlErr(errors.New("stopped after 10 redirects")), ErrorClassPermanent},
+		{"scheme", urlErr(errors.New("unsupported protocol scheme \"\"")), ErrorClassPermanent},
+		{"plaintext_server", urlErr(errors.New("http: server gave HTTP response to HTTPS client")), ErrorClassPermanent},
+		{"unknown", errors.New("something went wrong"), ErrorClassAmbiguous},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if class := DefaultErrorClassifier(tt.err); class != tt.class {
+				t.Fatalf("expected %s, got %s", tt.class, class)
+			}
+		})
+	}
+}
+
+func TestDefaultErrorClassifier_live(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name  string
+		url   string
+		class ErrorClass
+	}{
+		// Nothing is listening on this port.
+		{"connection_refused", "http://127.0.0.1:1/", ErrorClassTransient},
+		// The server only speaks plaintext HTTP.
+		{"plaintext_server", "https" + ts.URL[len("http"):], ErrorClassPermanent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &http.Client{Timeout: 5 * time.Second}
+			_, err := client.Get(tt.url)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if class := DefaultErrorClassifier(err); class != tt.class {
+				t.Fatalf("expected %s for %v, got %s", tt.class, err, class)
+			}
+		})
+	}
+}
+
+func TestClassifyingRetryPolicy(t *testing.T) {
+	errFlaky := errors.New("flaky")
+	classify := func(err error) ErrorClass {
+		if errors.Is(err, errFlaky) {
+			return ErrorClassTransient
+		}
+		return ErrorClassPermanent
+	}
+	policy := ClassifyingRetryPolicy(classify)
+
+	tests := []struct {
+		err   error
+		retry bool
+	}{
+		{errFlaky, true},
+		{fmt.Errorf("wrapped: %w", errFlaky), true},
+		{errors.New("other"), false},
+	}
+	for _, tt := range tests {
+		retry, err := policy(context.Background(), nil, tt.err)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if retry != tt.retry {
+			t.Fatalf("expected retry=%t for %v, got %t", tt.retry, tt.err, retry)
+		}
+	}
+
+	// A 500 is retried regardless of the classifier.
+	retry, _ := policy(context.Background(), &http.Response{StatusCode: 500, Status: "500 Internal Server Error"}, nil)
+	if !retry {
+		t.Fatal("expected a 500 response to be retried")
+	}
+}
@@ -30,12 +30,9 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"math"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -64,26 +61,6 @@ var (
 	// timeNow sets the function that returns the current time.
 	// This defaults to time.Now. Changes to this should only be done in tests.
 	timeNow = time.Now
-
-	// A regular expression to match the error returned by net/http when the
-	// configured number of redirects is exhausted. This error isn't typed
-	// specifically so we resort to matching on the error string.
-	redirectsErrorRe = regexp.MustCompile(`stopped after \d+ redirects\z`)
-
-	// A regular expression to match the error returned by net/http when the
-	// scheme specified in the URL is invalid. This error isn't typed
-	// specifically so we resort to matching on the error string.
-	schemeErrorRe = regexp.MustCompile(`unsupported protocol scheme`)
-
-	// A regular expression to match the error returned by net/http when a
-	// request header or value is invalid. This error isn't typed
-	// specifically so we resort to matching on the error string.
-	invalidHeaderErrorRe = regexp.MustCompile(`invalid header`)
-
-	// A regular expression to match the error returned by net/http when the
-	// TLS certificate is not trusted. This error isn't typed
-	// specifically so we resort to matching on the error string.
-	notTrustedErrorRe = regexp.MustCompile(`certificate is not trusted`)
 )
 
 // ReaderFunc is the type of function that can be given natively to NewRequest
@@ -115,6 +92,10 @@ type Request struct {
 
 	responseHandler ResponseHandlerFunc
 
+	// overrides holds retry settings which take precedence over those of
+	// the Client for this request.
+	overrides retryOverrides
+
 	// Embed an HTTP request directly. This makes a *Request act exactly
 	// like an *http.Request so that all meta methods are supported.
 	*http.Request
@@ -126,6 +107,7 @@ func (r *Request) WithContext(ctx context.Context) *Request {
 	return &Request{
 		body:            r.body,
 		responseHandler: r.responseHandler,
+		overrides:       r.overrides,
 		Request:         r.Request.WithContext(ctx),
 	}
 }
@@ -135,6 +117,55 @@ func (r *Request) SetResponseHandler(fn ResponseHandlerFunc) {
 	r.responseHandler = fn
 }
 
+// SetRetryMax sets the maximum number of retries of the request, overriding
+// Client.RetryMax.
+func (r *Request) SetRetryMax(retryMax int) {
+	r.overrides.retryMax = &retryMax
+}
+
+// SetRetryWait sets the minimum and maximum time to wait between retries of
+// the request, overriding Client.RetryWaitMin and Client.RetryWaitMax.
+func (r *Request) SetRetryWait(min, max time.Duration) {
+	r.overrides.retryWaitMin = &min
+	r.overrides.retryWaitMax = &max
+}
+
+// SetCheckRetry sets the retry policy of the request, overriding both
+// Client.CheckRetry and Client.CheckRetryV2.
+func (r *Request) SetCheckRetry(fn CheckRetry) {
+	r.overrides.checkRetry = fn
+}
+
+// SetBackoff sets the backoff policy of the request, overriding
+// Client.Backoff.
+func (r *Request) SetBackoff(fn Backoff) {
+	r.overrides.backoff = fn
+}
+
+// SetMaxElapsedTime sets the time after which the request is no longer
+// retried, overriding Client.MaxElapsedTime.
+func (r *Request) SetMaxElapsedTime(max time.Duration) {
+	r.overrides.maxElapsedTime = &max
+}
+
+// SetAttemptTimeout sets the time each attempt of the request may take,
+// overriding Client.AttemptTimeout.
+func (r *Request) SetAttemptTimeout(timeout time.Duration) {
+	r.overrides.attemptTimeout = &timeout
+}
+
+// SetPrepareRetry sets the function preparing retries of the request,
+// overriding Client.PrepareRetry.
+func (r *Request) SetPrepareRetry(fn PrepareRetry) {
+	r.overrides.prepareRetry = fn
+}
+
+// DisableRetries makes the request be attempted only once. It is the same
+// as SetRetryMax(0).
+func (r *Request) DisableRetries() {
+	r.SetRetryMax(0)
+}
+
 // BodyBytes allows accessing the request body. It is an analogue to
 // http.Request's Body variable, but it returns a copy of the underlying data
 // rather than consuming it.
@@ -267,7 +298,7 @@ func getBodyReaderAndContentLength(rawBody interface{}) (ReaderFunc, int64, erro
 		raw := body
 		bodyReader = func() (io.Reader, error) {
 			_, err := raw.Seek(0, 0)
-			return io.NopCloser(raw), err
+			return &rewoundSeeker{raw}, err
 		}
 		if lr, ok := raw.(LenReader); ok {
 			contentLength = int64(lr.Len())
@@ -301,6 +332,14 @@ func getBodyReaderAndContentLength(rawBody interface{}) (ReaderFunc, int64, erro
 	return bodyReader, contentLength, nil
 }
 
+// rewoundSeeker is the body of an attempt read from an io.ReadSeeker given
+// as the body of the request, which all attempts share.
+type rewoundSeeker struct {
+	io.Reader
+}
+
+func (*rewoundSeeker) Close() error { return nil }
+
 // FromRequest wraps an http.Request in a retryablehttp.Request
 func FromRequest(r *http.Request) (*Request, error) {
 	bodyReader, _, err := getBodyReaderAndContentLength(r.Body)
@@ -387,6 +426,50 @@ type ResponseLogHook func(Logger, *http.Response)
 // response body before returning.
 type CheckRetry func(ctx context.Context, resp *http.Response, err error) (bool, error)
 
+// RetryDecision is the outcome of a CheckRetryV2 policy.
+type RetryDecision struct {
+	// Retry reports whether the request should be retried.
+	Retry bool
+
+	// Err, if set, is returned in lieu of the error from the request, like
+	// the error returned by a CheckRetry.
+	Err error
+
+	// Wait, if positive, is how long to wait before the next attempt,
+	// overriding the client's Backoff.
+	Wait time.Duration
+
+	// Reason is a human-readable explanation of the decision. It is logged
+	// with each retry and included in the error returned when giving up.
+	Reason string
+
+	// FreshConnection asks for the next attempt not to reuse a pooled
+	// connection, for example because the current one is suspected to be
+	// broken.
+	FreshConnection bool
+
+	// URL, if set, is where the next attempt is sent, for example the next
+	// endpoint of a replicated service.
+	URL *url.URL
+}
+
+// CheckRetryV2 is a richer alternative to CheckRetry. In addition to the
+// response and error, it receives the request of the attempt and information
+// about the attempt, and it returns a RetryDecision which can override the
+// backoff and explain itself. As with CheckRetry, the Client will close any
+// response body when retrying, but if the retry is aborted it is up to the
+// policy to close the response body before returning.
+type CheckRetryV2 func(ctx context.Context, req *http.Request, resp *http.Response, err error, attempt AttemptInfo) RetryDecision
+
+// AdaptCheckRetry returns a CheckRetryV2 which makes the same decisions as
+// the given CheckRetry.
+func AdaptCheckRetry(checkRetry CheckRetry) CheckRetryV2 {
+	return func(ctx context.Context, _ *http.Request, resp *http.Response, err error, _ AttemptInfo) RetryDecision {
+		shouldRetry, checkErr := checkRetry(ctx, resp, err)
+		return RetryDecision{Retry: shouldRetry, Err: checkErr}
+	}
+}
+
 // Backoff specifies a policy for how long to wait between retries.
 // It is called after a failing request to determine the amount of time
 // that should pass before trying again.
@@ -423,15 +506,113 @@ type Client struct {
 	// after each request. The default policy is DefaultRetryPolicy.
 	CheckRetry CheckRetry
 
+	// CheckRetryV2, if set, is used instead of CheckRetry.
+	CheckRetryV2 CheckRetryV2
+
 	// Backoff specifies the policy for how long to wait between retries
 	Backoff Backoff
 
+	// Clock, if set, is used to tell the time and to wait between
+	// attempts, including when interpreting Retry-After headers. It
+	// defaults to the system clock; see FakeClock for tests.
+	Clock Clock
+
+	// Rand, if set, is the source of the random numbers drawn by the
+	// jittered backoffs of the package, such as LinearJitterBackoff and
+	// NewFullJitter. Seeding it, for example with NewRand, makes their
+	// schedules reproducible. It defaults to the top-level functions of
+	// math/rand.
+	Rand Rand
+
+	// NewBackoffStrategy, if set, is called once per request to obtain a
+	// BackoffStrategy, which is used instead of Backoff. A Backoff set by a
+	// Router or on a Request takes precedence over it.
+	NewBackoffStrategy func() BackoffStrategy
+
+	// Router, if set, picks the RetryMax, RetryWaitMin, RetryWaitMax,
+	// CheckRetry and Backoff of each attempt from the request. Requests
+	// matching none of its routes use the settings above. Settings made on
+	// a Request, such as with Request.SetRetryMax, take precedence over
+	// both.
+	Router *PolicyRouter
+
 	// ErrorHandler specifies the custom error handler to use, if any
 	ErrorHandler ErrorHandler
 
 	// PrepareRetry can prepare the request for retry operation, for example re-sign it
 	PrepareRetry PrepareRetry
 
+	// IdempotencyKey, if set, is used to generate an idempotency key for
+	// requests with non-idempotent methods, such as POST, which don't
+	// already carry one. The key is sent in the IdempotencyKeyHeader header
+	// and stays the same on every retry of the request.
+	IdempotencyKey IdempotencyKeyFunc
+
+	// IdempotencyKeyHeader is the name of the header carrying the
+	// idempotency key. It defaults to "Idempotency-Key".
+	IdempotencyKeyHeader string
+
+	// MaxElapsedTime, if positive, stops retries once the next attempt
+	// would start later than MaxElapsedTime after the first one, whatever
+	// RetryMax says. If RetryMax is negative, the number of attempts is
+	// only bounded by MaxElapsedTime.
+	MaxElapsedTime time.Duration
+
+	// AttemptTimeout, if positive, bounds the time taken by each attempt,
+	// including reading the response body, independently of the request
+	// context. An attempt which runs out of time fails with
+	// ErrAttemptTimeout and is retried like any other timeout.
+	AttemptTimeout time.Duration
+
+	// AttemptTimeoutEscalation, if greater than 1, is the factor by which
+	// the attempt timeout grows with each retry, so that attempt n gets
+	// AttemptTimeout * AttemptTimeoutEscalation^(n-1).
+	AttemptTimeoutEscalation float64
+
+	// AttemptHeader, if set, is the name of a header, such as
+	// "X-Retry-Attempt", which is set to the attempt number on every
+	// attempt, starting at 1 for the initial request. Servers can use it to
+	// shed retries first when overloaded.
+	AttemptHeader string
+
+	// DeadlineHeader, if set, is the name of a header, such as
+	// "X-Request-Timeout", which tells the server how much time is left
+	// before the deadline of the request context. It is recomputed before
+	// every attempt and formatted like the grpc-timeout header, as an
+	// integer followed by a unit, such as "2500m" for 2.5 seconds. Requests
+	// whose context has no deadline don't carry the header.
+	DeadlineHeader string
+
+	// CircuitBreaker, if set, is consulted before every attempt, and told
+	// whether it failed according to the retry policy. While the circuit of
+	// a request is open, Do fails fast with a *CircuitOpenError instead of
+	// making the attempt.
+	CircuitBreaker *CircuitBreaker
+
+	// RetryBudget, if set, limits the retries made across all requests,
+	// such as to a fraction of the requests (see NewRetryBudget). Once it
+	// is exhausted, Do gives up on requests after their current attempt.
+	RetryBudget RetryBudget
+
+	// Throttle, if set, rejects attempts with a *ThrottledError before
+	// they are sent, with a probability which grows as the upstream rejects
+	// more of them. See AdaptiveThrottle.
+	Throttle *AdaptiveThrottle
+
+	// Hedge, if set, sends extra copies of the attempts of requests with
+	// idempotent methods when the response is slow to come, and uses the
+	// first response. RequestLogHook is called for every copy, and
+	// ResponseLogHook for every response received until one wins, the
+	// winner included, but not for those of the copies then canceled. The
+	// copies of an attempt count as a single attempt for the Throttle,
+	// CircuitBreaker and RetryBudget, which only see its outcome.
+	Hedge *HedgePolicy
+
+	// Coalesce, if set, makes concurrent identical requests share a single
+	// execution, each caller getting its own copy of the response. See
+	// Coalescer.
+	Coalesce *Coalescer
+
 	loggerInit sync.Once
 	clientInit sync.Once
 }
@@ -476,7 +657,7 @@ func DefaultRetryPolicy(ctx context.Context, resp *http.Response, err error) (bo
 	}
 
 	// don't propagate other errors
-	shouldRetry, _ := baseRetryPolicy(resp, err)
+	shouldRetry, _ := baseRetryPolicy(resp, err, DefaultErrorClassifier)
 	return shouldRetry, nil
 }
 
@@ -489,34 +670,14 @@ func ErrorPropagatedRetryPolicy(ctx context.Context, resp *http.Response, err er
 		return false, ctx.Err()
 	}
 
-	return baseRetryPolicy(resp, err)
+	return baseRetryPolicy(resp, err, DefaultErrorClassifier)
 }
 
-func baseRetryPolicy(resp *http.Response, err error) (bool, error) {
+func baseRetryPolicy(resp *http.Response, err error, classify ErrorClassifier) (bool, error) {
 	if err != nil {
-		if v, ok := err.(*url.Error); ok {
-			// Don't retry if the error was due to too many redirects.
-			if redirectsErrorRe.MatchString(v.Error()) {
-				return false, v
-			}
-
-			// Don't retry if the error was due to an invalid protocol scheme.
-			if schemeErrorRe.MatchString(v.Error()) {
-				return false, v
-			}
-
-			// Don't retry if the error was due to an invalid header.
-			if invalidHeaderErrorRe.MatchString(v.Error()) {
-				return false, v
-			}
-
-			// Don't retry if the error was due to TLS cert verification failure.
-			if notTrustedErrorRe.MatchString(v.Error()) {
-				return false, v
-			}
-			if isCertError(v.Err) {
-				return false, v
-			}
+		// Don't retry errors which won't go away by retrying.
+		if classify(err) == ErrorClassPermanent {
+			return false, err
 		}
 
 		// The error is likely recoverable so retry.
@@ -549,20 +710,10 @@ func baseRetryPolicy(resp *http.Response, err error) (bool, error) {
 // (HTTP Code 429) is found in the resp parameter. Hence it will return the number of
 // seconds the server states it may be ready to process more requests from this client.
 func DefaultBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
-	if resp != nil {
-		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
-			if sleep, ok := parseRetryAfterHeader(resp.Header["Retry-After"]); ok {
-				return sleep
-			}
-		}
+	if sleep, ok := retryAfter(resp); ok {
+		return sleep
 	}
-
-	mult := math.Pow(2, float64(attemptNum)) * float64(min)
-	sleep := time.Duration(mult)
-	if float64(sleep) != mult || sleep > max {
-		sleep = max
-	}
-	return sleep
+	return exponential(min, max, attemptNum)
 }
 
 // parseRetryAfterHeader parses the Retry-After header and returns the
@@ -576,6 +727,12 @@ func DefaultBackoff(min, max time.Duration, attemptNum int, resp *http.Response)
 // * Retry-After: Fri, 31 Dec 1999 23:59:59 GMT
 // * Retry-After: 120
 func parseRetryAfterHeader(headers []string) (time.Duration, bool) {
+	return parseRetryAfterHeaderAt(headers, timeNow())
+}
+
+// parseRetryAfterHeaderAt is like parseRetryAfterHeader, with now as the
+// current time.
+func parseRetryAfterHeaderAt(headers []string, now time.Time) (time.Duration, bool) {
 	if len(headers) == 0 || headers[0] == "" {
 		return 0, false
 	}
@@ -593,7 +750,7 @@ func parseRetryAfterHeader(headers []string) (time.Duration, bool) {
 	if err != nil {
 		return 0, false
 	}
-	if until := retryTime.Sub(timeNow()); until > 0 {
+	if until := retryTime.Sub(now); until > 0 {
 		return until, true
 	}
 	// date is in the past
@@ -616,7 +773,15 @@ func parseRetryAfterHeader(headers []string) (time.Duration, bool) {
 // (892ms, 2102ms, 2945ms, 4312ms, ...)
 // * To get extreme jitter, set to a very wide spread, such as a min of 100ms
 // and a max of 20s (15382ms, 292ms, 51321ms, 35234ms, ...)
+//
+// When used by a Client, the jitter is drawn from Client.Rand.
 func LinearJitterBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	return linearJitterBackoff(randFromResponse(resp), min, max, attemptNum, resp)
+}
+
+// linearJitterBackoff implements LinearJitterBackoff, drawing the jitter
+// from rnd.
+func linearJitterBackoff(rnd Rand, min, max time.Duration, attemptNum int, _ *http.Response) time.Duration {
 	// attemptNum always starts at zero but we want to start at 1 for multiplication
 	attemptNum++
 
@@ -626,14 +791,11 @@ func LinearJitterBackoff(min, max time.Duration, attemptNum int, resp *http.Resp
 		return min * time.Duration(attemptNum)
 	}
 
-	// Seed rand; doing this every time is fine
-	source := rand.New(rand.NewSource(int64(time.Now().Nanosecond())))
-
 	// Pick a random number that lies somewhere between the min and max and
 	// multiply by the attemptNum. attemptNum starts at zero so we always
 	// increment here. We first get a random percentage, then apply that to the
 	// difference between min and max, and add to min.
-	jitter := source.Float64() * float64(max-min)
+	jitter := rnd.Float64() * float64(max-min)
 	jitterMin := int64(jitter) + int64(min)
 	return time.Duration(jitterMin * int64(attemptNum))
 }
@@ -645,14 +807,16 @@ func LinearJitterBackoff(min, max time.Duration, attemptNum int, resp *http.Resp
 // amount of time specified by the header. Otherwise, this calls
 // LinearJitterBackoff.
 func RateLimitLinearJitterBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
-	if resp != nil {
-		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
-			if sleep, ok := parseRetryAfterHeader(resp.Header["Retry-After"]); ok {
-				return sleep
-			}
-		}
+	return rateLimitLinearJitterBackoff(randFromResponse(resp), min, max, attemptNum, resp)
+}
+
+// rateLimitLinearJitterBackoff implements RateLimitLinearJitterBackoff,
+// drawing the jitter from rnd.
+func rateLimitLinearJitterBackoff(rnd Rand, min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+	if sleep, ok := retryAfter(resp); ok {
+		return sleep
 	}
-	return LinearJitterBackoff(min, max, attemptNum, resp)
+	return linearJitterBackoff(rnd, min, max, attemptNum, resp)
 }
 
 // PassthroughErrorHandler is an ErrorHandler that directly passes through the
@@ -664,6 +828,18 @@ func PassthroughErrorHandler(resp *http.Response, err error, _ int) (*http.Respo
 
 // Do wraps calling an HTTP method with retries.
 func (c *Client) Do(req *Request) (*http.Response, error) {
+	resp, _, err := c.doCoalesced(req)
+	return resp, err
+}
+
+// DoWithInfo is like Do, but additionally returns a RetryInfo describing
+// every attempt that was made, including when the request eventually
+// succeeded. The returned RetryInfo is never nil.
+func (c *Client) DoWithInfo(req *Request) (*http.Response, *RetryInfo, error) {
+	return c.doCoalesced(req)
+}
+
+func (c *Client) do(req *Request) (*http.Response, *RetryInfo, error) {
 	c.clientInit.Do(func() {
 		if c.HTTPClient == nil {
 			c.HTTPClient = cleanhttp.DefaultPooledClient()
@@ -671,31 +847,51 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 	})
 
 	logger := c.logger()
+	clock := c.clock()
+
+	info := &RetryInfo{}
+
+	idempotencyKey, keyErr := c.setIdempotencyKey(req)
+	if keyErr != nil {
+		return nil, info, keyErr
+	}
 
 	if logger != nil {
 		switch v := logger.(type) {
 		case LeveledLogger:
-			v.Debug("performing request", "method", req.Method, "url", redactURL(req.URL))
+			v.Debug("performing request", logKeyvals(idempotencyKey, "method", req.Method, "url", redactURL(req.URL))...)
 		case Logger:
-			v.Printf("[DEBUG] %s %s", req.Method, redactURL(req.URL))
+			v.Printf("[DEBUG] %s %s%s", req.Method, redactURL(req.URL), logSuffix(idempotencyKey))
 		}
 	}
 
 	var resp *http.Response
-	var attempt int
+	var decision RetryDecision
 	var shouldRetry bool
 	var doErr, respErr, checkErr, prepareErr error
 
-	for i := 0; ; i++ {
-		doErr, respErr, prepareErr = nil, nil, nil
-		attempt++
+	// strategy is the BackoffStrategy of the request, obtained on the first
+	// retry which needs it.
+	var strategy BackoffStrategy
+
+	// stopKind, if set, is why the loop stopped retrying before CheckRetry
+	// or RetryMax said so.
+	var stopKind error
 
+	// throttleKey is the key of the next attempt in the AdaptiveThrottle of
+	// the client, which lets retries through before waiting for them.
+	var throttleKey string
+
+	for i := 0; ; i++ {
 		// Always rewind the request body when non-nil.
 		if req.body != nil {
 			body, err := req.body()
 			if err != nil {
+				if i > 0 && doErr == nil {
+					c.drainBody(resp.Body)
+				}
 				c.HTTPClient.CloseIdleConnections()
-				return resp, err
+				return resp, info, err
 			}
 			if c, ok := body.(io.ReadCloser); ok {
 				req.Body = c
@@ -704,90 +900,249 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 			}
 		}
 
-		if c.RequestLogHook != nil {
-			switch v := logger.(type) {
-			case LeveledLogger:
-				c.RequestLogHook(hookLogger{v}, req.Request, i)
-			case Logger:
-				c.RequestLogHook(v, req.Request, i)
-			default:
-				c.RequestLogHook(nil, req.Request, i)
+		// Each attempt carries its own state in the request context, which
+		// lets retry policies know what happened to this particular attempt.
+		// The settings are looked up on every attempt, since a retry
+		// policy can send the next attempt elsewhere.
+		settings := c.retrySettings(req)
+
+		attempt := c.attemptInfo(req, info, settings, resp)
+		attemptCtx := newAttemptContext(req.Context(), req.Request, attempt, c)
+		// The attempt timeout only applies to the request made for the
+		// attempt, so that policies still see whether the caller gave up.
+		timeoutCtx, cancelAttempt := settings.attemptContext(attemptCtx, attempt)
+		attemptReq := c.setAttemptHeaders(req.Request.WithContext(timeoutCtx), attempt)
+
+		if i == 0 {
+			var err error
+			if throttleKey, err = c.throttleAttempt(logger, attemptReq); err != nil {
+				cancelAttempt()
+				return nil, info, err
+			}
+		}
+
+		// The circuit was checked before waiting for a retry, but a probe of
+		// a half-open circuit is only taken once the attempt is made.
+		var circuitKey string
+		if c.CircuitBreaker != nil {
+			circuitKey = c.CircuitBreaker.key(attemptReq)
+			t, err := c.CircuitBreaker.allow(circuitKey, clock.Now())
+			c.logCircuitTransition(logger, t)
+			if err != nil {
+				cancelAttempt()
+				if i == 0 {
+					return nil, info, err
+				}
+				// Other requests took the probes while this one waited, so
+				// give up with the outcome of the last attempt.
+				stopKind = err
+				if logger != nil {
+					switch v := logger.(type) {
+					case LeveledLogger:
+						v.Debug("not retrying request", "method", req.Method, "url", redactURL(req.URL), "reason", stopKind)
+					case Logger:
+						v.Printf("[DEBUG] %s %s: not retrying: %v", req.Method, redactURL(req.URL), stopKind)
+					}
+				}
+				break
+			}
+		}
+
+		// The retry is made, so consume the last response to reuse the
+		// connection. It was kept until now in case it was the outcome of
+		// the request.
+		if i > 0 {
+			if doErr == nil {
+				c.drainBody(resp.Body)
+			}
+			// The policy asked for this attempt not to reuse a connection,
+			// so get rid of any that are pooled.
+			if decision.FreshConnection {
+				c.HTTPClient.CloseIdleConnections()
 			}
 		}
+		doErr, respErr = nil, nil
+
+		if c.RetryBudget != nil && i == 0 {
+			c.RetryBudget.Deposit(attemptReq)
+		}
+
+		c.requestLogHook(logger, attemptReq, i)
 
 		// Attempt the request
-		resp, doErr = c.HTTPClient.Do(req.Request)
+		start := clock.Now()
+		resp, doErr = c.sendAttempt(logger, req, attemptReq, i, &settings, attempt)
+		duration := clock.Now().Sub(start)
+		resp, doErr = finishAttempt(attemptCtx, timeoutCtx, cancelAttempt, resp, doErr)
 
 		// Check if we should continue with retries.
-		shouldRetry, checkErr = c.CheckRetry(req.Context(), resp, doErr)
-		if !shouldRetry && doErr == nil && req.responseHandler != nil {
+		decision = settings.check(attemptCtx, attemptReq, resp, doErr, attempt)
+		if !decision.Retry && doErr == nil && req.responseHandler != nil {
 			respErr = req.responseHandler(resp)
-			shouldRetry, checkErr = c.CheckRetry(req.Context(), resp, respErr)
+			decision = settings.check(attemptCtx, attemptReq, resp, respErr, attempt)
+		}
+		shouldRetry, checkErr = decision.Retry, decision.Err
+		if retriesExhausted(decision) {
+			// The policy used up the retries it allows for a failure.
+			shouldRetry, checkErr, stopKind = true, nil, ErrRetriesExhausted
+		}
+
+		if c.CircuitBreaker != nil {
+			result := circuitSuccess
+			if req.Context().Err() != nil {
+				result = circuitIgnored
+			} else if shouldRetry {
+				result = circuitFailure
+			}
+			c.logCircuitTransition(logger, c.CircuitBreaker.record(circuitKey, result, clock.Now()))
+		}
+		if c.Throttle != nil && req.Context().Err() == nil {
+			c.Throttle.record(throttleKey, !shouldRetry, clock.Now())
 		}
 
 		err := doErr
 		if respErr != nil {
 			err = respErr
 		}
+
+		record := Attempt{
+			Number:      i + 1,
+			Err:         err,
+			ShouldRetry: shouldRetry,
+			CheckErr:    checkErr,
+			Reason:      decision.Reason,
+			Start:       start,
+			Duration:    duration,
+		}
+		if resp != nil {
+			record.StatusCode = resp.StatusCode
+		}
+		info.Attempts = append(info.Attempts, record)
+
 		if err != nil {
 			switch v := logger.(type) {
 			case LeveledLogger:
-				v.Error("request failed", "error", err, "method", req.Method, "url", redactURL(req.URL))
+				v.Error("request failed", logKeyvals(idempotencyKey, "error", err, "method", req.Method, "url", redactURL(req.URL))...)
 			case Logger:
-				v.Printf("[ERR] %s %s request failed: %v", req.Method, redactURL(req.URL), err)
+				v.Printf("[ERR] %s %s%s request failed: %v", req.Method, redactURL(req.URL), logSuffix(idempotencyKey), err)
 			}
 		} else {
 			// Call this here to maintain the behavior of logging all requests,
 			// even if CheckRetry signals to stop.
-			if c.ResponseLogHook != nil {
-				// Call the response logger function if provided.
-				switch v := logger.(type) {
-				case LeveledLogger:
-					c.ResponseLogHook(hookLogger{v}, resp)
-				case Logger:
-					c.ResponseLogHook(v, resp)
-				default:
-					c.ResponseLogHook(nil, resp)
-				}
-			}
+			c.responseLogHook(logger, resp)
 		}
 
 		if !shouldRetry {
+			if c.RetryBudget != nil && err == nil && checkErr == nil {
+				c.RetryBudget.Refund(attemptReq)
+			}
+			break
+		}
+		if stopKind != nil {
 			break
 		}
 
 		// We do this before drainBody because there's no need for the I/O if
 		// we're breaking out
-		remain := c.RetryMax - i
-		if remain <= 0 {
+		remain := settings.retryMax - i
+		unlimited := settings.retryMax < 0 && settings.maxElapsedTime > 0
+		if remain <= 0 && !unlimited {
 			break
 		}
 
-		// We're going to retry, consume any response to reuse the connection.
-		if doErr == nil {
-			c.drainBody(resp.Body)
+		wait := decision.Wait
+		if wait <= 0 {
+			if settings.newBackoffStrategy != nil {
+				if strategy == nil {
+					strategy = settings.newBackoffStrategy()
+					if setter, ok := strategy.(randSetter); ok {
+						setter.setRand(c.rnd())
+					}
+					strategy.Reset()
+				}
+				wait = strategy.Next(c.attemptInfo(req, info, settings, resp))
+			} else {
+				wait = settings.backoff(c.rnd(), settings.retryWaitMin, settings.retryWaitMax, i, resp)
+			}
+		}
+
+		// Stop once the next attempt would start after MaxElapsedTime. And
+		// don't sleep only to have the context expire before or during the
+		// next attempt, which is assumed to take as long as this one. Give
+		// up now with the error of this attempt instead.
+		if settings.maxElapsedTime > 0 && clock.Now().Add(wait).Sub(info.Attempts[0].Start) > settings.maxElapsedTime {
+			stopKind = ErrMaxElapsedTime
+		} else if deadline, ok := req.Context().Deadline(); ok && timeNow().Add(wait+duration).After(deadline) {
+			stopKind = ErrWouldExceedDeadline
+		} else if c.Throttle != nil || c.CircuitBreaker != nil {
+			// Don't wait for an attempt which would be rejected, and keep
+			// this attempt as the outcome of the request if it is.
+			next := req.Request
+			if decision.URL != nil {
+				nextReq := *req.Request
+				nextReq.URL, nextReq.Host = decision.URL, ""
+				next = &nextReq
+			}
+			var admitErr error
+			if throttleKey, admitErr = c.throttleAttempt(logger, next); admitErr != nil {
+				stopKind = admitErr
+			} else if c.CircuitBreaker != nil {
+				if admitErr = c.CircuitBreaker.check(c.CircuitBreaker.key(next), clock.Now().Add(wait)); admitErr != nil {
+					stopKind = admitErr
+				}
+			}
+		}
+		// The retry is only paid for once nothing else stands in its way.
+		if stopKind == nil && c.RetryBudget != nil && !c.RetryBudget.Withdraw(attemptReq, resp, err) {
+			stopKind = ErrRetryBudgetExhausted
+			if decision.Reason != "" {
+				decision.Reason = fmt.Sprintf("%s; %s", decision.Reason, ErrRetryBudgetExhausted)
+			} else {
+				decision.Reason = ErrRetryBudgetExhausted.Error()
+			}
+		}
+		if stopKind != nil {
+			if logger != nil {
+				switch v := logger.(type) {
+				case LeveledLogger:
+					v.Debug("not retrying request", "method", req.Method, "url", redactURL(req.URL), "timeout", wait, "reason", stopKind)
+				case Logger:
+					v.Printf("[DEBUG] %s %s: not retrying in %s: %v", req.Method, redactURL(req.URL), wait, stopKind)
+				}
+			}
+			break
 		}
 
-		wait := c.Backoff(c.RetryWaitMin, c.RetryWaitMax, i, resp)
+		info.Attempts[len(info.Attempts)-1].Wait = wait
 		if logger != nil {
-			desc := fmt.Sprintf("%s %s", req.Method, redactURL(req.URL))
+			desc := fmt.Sprintf("%s %s%s", req.Method, redactURL(req.URL), logSuffix(idempotencyKey))
 			if resp != nil {
 				desc = fmt.Sprintf("%s (status: %d)", desc, resp.StatusCode)
 			}
+			if decision.Reason != "" {
+				desc = fmt.Sprintf("%s (reason: %s)", desc, decision.Reason)
+			}
+			var left interface{} = remain
+			if unlimited {
+				left = "unlimited"
+			}
 			switch v := logger.(type) {
 			case LeveledLogger:
-				v.Debug("retrying request", "request", desc, "timeout", wait, "remaining", remain)
+				v.Debug("retrying request", "request", desc, "timeout", wait, "remaining", left)
 			case Logger:
-				v.Printf("[DEBUG] %s: retrying in %s (%d left)", desc, wait, remain)
+				v.Printf("[DEBUG] %s: retrying in %s (%v left)", desc, wait, left)
 			}
 		}
-		timer := time.NewTimer(wait)
+		timer := clock.NewTimer(wait)
 		select {
 		case <-req.Context().Done():
 			timer.Stop()
+			if doErr == nil {
+				c.drainBody(resp.Body)
+			}
 			c.HTTPClient.CloseIdleConnections()
-			return nil, req.Context().Err()
-		case <-timer.C:
+			return nil, info, req.Context().Err()
+		case <-timer.C():
 		}
 
 		// Make shallow copy of http Request so that we can modify its body
@@ -795,24 +1150,52 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 		httpreq := *req.Request
 		req.Request = &httpreq
 
-		if c.PrepareRetry != nil {
-			if err := c.PrepareRetry(req.Request); err != nil {
+		// Send the next attempt to the endpoint chosen by the policy.
+		if decision.URL != nil {
+			req.URL = decision.URL
+			req.Host = ""
+		}
+
+		if settings.prepareRetry != nil {
+			// Let PrepareRetry see the upcoming attempt in the context, then
+			// restore the caller's context for the attempt itself.
+			ctx := req.Context()
+			next := c.attemptInfo(req, info, settings, resp)
+			req.Request = req.Request.WithContext(newAttemptContext(ctx, req.Request, next, c))
+			err := settings.prepareRetry(req.Request)
+			req.Request = req.Request.WithContext(ctx)
+			if err != nil {
 				prepareErr = err
 				break
 			}
 		}
+
+		// Make sure the retry carries the same key even if PrepareRetry
+		// replaced or cleared the headers.
+		if idempotencyKey != "" && req.Header.Get(c.idempotencyKeyHeader()) == "" {
+			req.Header = req.Header.Clone()
+			req.Header.Set(c.idempotencyKeyHeader(), idempotencyKey)
+		}
 	}
 
 	// this is the closest we have to success criteria
 	if doErr == nil && respErr == nil && checkErr == nil && prepareErr == nil && !shouldRetry {
-		return resp, nil
+		return resp, info, nil
 	}
 
 	defer c.HTTPClient.CloseIdleConnections()
 
 	var err error
+	kind := ErrCheckRetryAborted
+	if shouldRetry {
+		kind = ErrRetriesExhausted
+	}
+	if stopKind != nil {
+		kind = stopKind
+	}
 	if prepareErr != nil {
 		err = prepareErr
+		kind = ErrPrepareRetryFailed
 	} else if checkErr != nil {
 		err = checkErr
 	} else if respErr != nil {
@@ -820,9 +1203,15 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 	} else {
 		err = doErr
 	}
+	if err == nil && stopKind != nil && resp != nil {
+		// Report the status of the last attempt as its failure, since the
+		// request was given up early.
+		err = fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
 
 	if c.ErrorHandler != nil {
-		return c.ErrorHandler(resp, err, attempt)
+		resp, err = c.ErrorHandler(resp, err, len(info.Attempts))
+		return resp, info, err
 	}
 
 	// By default, we close the response body and return an error without
@@ -831,15 +1220,93 @@ func (c *Client) Do(req *Request) (*http.Response, error) {
 		c.drainBody(resp.Body)
 	}
 
-	// this means CheckRetry thought the request was a failure, but didn't
-	// communicate why
-	if err == nil {
-		return nil, fmt.Errorf("%s %s giving up after %d attempt(s)",
-			req.Method, redactURL(req.URL), attempt)
+	// err may be nil here, which means CheckRetry thought the request was a
+	// failure, but didn't communicate why
+	return nil, info, &RetryError{
+		Method:   req.Method,
+		URL:      redactURL(req.URL),
+		Attempts: info.Attempts,
+		Err:      err,
+		Kind:     kind,
+		Reason:   decision.Reason,
+	}
+}
+
+// throttleAttempt asks the AdaptiveThrottle of the client, if any, to let
+// through an attempt of req. It returns the key of the attempt, whose result
+// must then be recorded, or the *ThrottledError rejecting it.
+func (c *Client) throttleAttempt(logger interface{}, req *http.Request) (string, error) {
+	if c.Throttle == nil {
+		return "", nil
+	}
+	key := c.Throttle.key(req)
+	if err := c.Throttle.allow(key, c.rnd(), c.clock().Now()); err != nil {
+		if logger != nil {
+			switch v := logger.(type) {
+			case LeveledLogger:
+				v.Debug("request throttled", "method", req.Method, "url", redactURL(req.URL), "error", err)
+			case Logger:
+				v.Printf("[DEBUG] %s %s: %v", req.Method, redactURL(req.URL), err)
+			}
+		}
+		return "", err
+	}
+	return key, nil
+}
+
+// requestLogHook calls the RequestLogHook of the client, if any, with the
+// request of attempt number i.
+func (c *Client) requestLogHook(logger interface{}, req *http.Request, i int) {
+	if c.RequestLogHook == nil {
+		return
+	}
+	switch v := logger.(type) {
+	case LeveledLogger:
+		c.RequestLogHook(hookLogger{v}, req, i)
+	case Logger:
+		c.RequestLogHook(v, req, i)
+	default:
+		c.RequestLogHook(nil, req, i)
+	}
+}
+
+// responseLogHook calls the ResponseLogHook of the client, if any.
+func (c *Client) responseLogHook(logger interface{}, resp *http.Response) {
+	if c.ResponseLogHook == nil {
+		return
+	}
+	switch v := logger.(type) {
+	case LeveledLogger:
+		c.ResponseLogHook(hookLogger{v}, resp)
+	case Logger:
+		c.ResponseLogHook(v, resp)
+	default:
+		c.ResponseLogHook(nil, resp)
 	}
+}
 
-	return nil, fmt.Errorf("%s %s giving up after %d attempt(s): %w",
-		req.Method, redactURL(req.URL), attempt, err)
+// attemptInfo describes the next attempt of req, given the attempts made so
+// far and the response to the last one, if any.
+func (c *Client) attemptInfo(req *Request, info *RetryInfo, settings retrySettings, prevResp *http.Response) AttemptInfo {
+	attempt := AttemptInfo{
+		Number:         len(info.Attempts) + 1,
+		MaxAttempts:    settings.retryMax + 1,
+		IdempotencyKey: req.Header.Get(c.idempotencyKeyHeader()),
+	}
+	if settings.retryMax < 0 {
+		attempt.MaxAttempts = 0
+		if settings.maxElapsedTime <= 0 {
+			attempt.MaxAttempts = 1
+		}
+	}
+	if n := len(info.Attempts); n > 0 {
+		prev := info.Attempts[n-1]
+		attempt.Elapsed = c.clock().Now().Sub(info.Attempts[0].Start)
+		attempt.PrevStatus = prev.StatusCode
+		attempt.PrevErr = prev.Err
+		attempt.PrevResponse = prevResp
+	}
+	return attempt
 }
 
 // Try to read the response body so we can reuse this connection.
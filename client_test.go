@@ -1403,3 +1403,169 @@ func TestClient_RedirectWithBody(t *testing.T) {
 		t.Fatalf("Expected the client to be redirected 2 times, got: %d", atomic.LoadInt32(&redirects))
 	}
 }
+
+func TestClient_CheckRetryV2(t *testing.T) {
+	var conns int32
+	var requests int32
+	primary := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	primary.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	primary.Start()
+	defer primary.Close()
+
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer secondary.Close()
+	secondaryURL, err := url.Parse(secondary.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	buf := new(bytes.Buffer)
+	client := NewClient()
+	client.Logger = hclog.New(&hclog.LoggerOptions{Output: buf, Level: hclog.Debug})
+	// A backoff this long would time the test out if it were used.
+	client.Backoff = func(_, _ time.Duration, _ int, _ *http.Response) time.Duration {
+		return time.Hour
+	}
+
+	var attempts []AttemptInfo
+	client.CheckRetryV2 = func(_ context.Context, req *http.Request, resp *http.Response, err error, attempt AttemptInfo) RetryDecision {
+		attempts = append(attempts, attempt)
+		if resp.StatusCode == http.StatusOK {
+			return RetryDecision{}
+		}
+		decision := RetryDecision{
+			Retry:           true,
+			Wait:            time.Millisecond,
+			Reason:          fmt.Sprintf("primary unavailable on attempt %d", attempt.Number),
+			FreshConnection: true,
+		}
+		if attempt.Number == 2 {
+			decision.URL = secondaryURL
+		}
+		return decision
+	}
+
+	resp, info, err := client.DoWithInfo(mustNewRequest(t, "GET", primary.URL))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+
+	if len(attempts) != 3 || attempts[2].Number != 3 || attempts[2].MaxAttempts != client.RetryMax+1 {
+		t.Fatalf("unexpected attempts: %#v", attempts)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("expected 2 requests to the primary, got %d", got)
+	}
+	if got := atomic.LoadInt32(&conns); got != 2 {
+		t.Fatalf("expected a fresh connection for each attempt, got %d connections", got)
+	}
+	if info.TotalWait() != 2*time.Millisecond {
+		t.Fatalf("expected the wait override to be used, got %s", info.TotalWait())
+	}
+	if reason := info.Attempts[0].Reason; reason != "primary unavailable on attempt 1" {
+		t.Fatalf("unexpected reason %q", reason)
+	}
+	if !strings.Contains(buf.String(), "reason: primary unavailable on attempt 2") {
+		t.Fatalf("expected the reason to be logged: %s", buf.String())
+	}
+}
+
+func TestClient_CheckRetryV2_reasonInError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.CheckRetryV2 = func(_ context.Context, _ *http.Request, resp *http.Response, _ error, _ AttemptInfo) RetryDecision {
+		resp.Body.Close()
+		return RetryDecision{Reason: "maintenance window", Err: errors.New("service unavailable")}
+	}
+
+	_, err := client.Do(mustNewRequest(t, "GET", ts.URL))
+	want := fmt.Sprintf("GET %s giving up after 1 attempt(s) (maintenance window): service unavailable", ts.URL)
+	if err == nil || err.Error() != want {
+		t.Fatalf("expected %q, got %v", want, err)
+	}
+}
+
+func TestClient_Do_RequestOverrides(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	router := NewPolicyRouter()
+	if err := router.Add(PolicyRoute{Path: "/routed", Policy: RetryPolicy{RetryMax: 3}}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	client := NewClient()
+	client.RetryMax = 2
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.Router = router
+	client.CheckRetryV2 = func(context.Context, *http.Request, *http.Response, error, AttemptInfo) RetryDecision {
+		t.Fatalf("the client's CheckRetryV2 should have been overridden")
+		return RetryDecision{}
+	}
+
+	var waits [][2]time.Duration
+	var prepared int
+	req := mustNewRequest(t, "GET", ts.URL+"/routed")
+	req.SetRetryMax(1)
+	req.SetRetryWait(5*time.Millisecond, 7*time.Millisecond)
+	req.SetCheckRetry(DefaultRetryPolicy)
+	req.SetBackoff(func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		waits = append(waits, [2]time.Duration{min, max})
+		return time.Millisecond
+	})
+	req.SetPrepareRetry(func(*http.Request) error {
+		prepared++
+		return nil
+	})
+
+	// The overrides survive WithContext.
+	req = req.WithContext(context.Background())
+
+	_, info, _ := client.DoWithInfo(req)
+	if info.NumAttempts() != 2 || atomic.LoadInt32(&hits) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", info.NumAttempts())
+	}
+	if len(waits) != 1 || waits[0] != [2]time.Duration{5 * time.Millisecond, 7 * time.Millisecond} {
+		t.Fatalf("expected the request's backoff and waits to be used, got %v", waits)
+	}
+	if prepared != 1 {
+		t.Fatalf("expected the request's PrepareRetry to be called once, got %d", prepared)
+	}
+
+	client.CheckRetryV2 = nil
+	atomic.StoreInt32(&hits, 0)
+	req = mustNewRequest(t, "GET", ts.URL+"/routed")
+	req.DisableRetries()
+	_, info, _ = client.DoWithInfo(req.WithContext(context.Background()))
+	if info.NumAttempts() != 1 || atomic.LoadInt32(&hits) != 1 {
+		t.Fatalf("expected a single attempt, got %d", info.NumAttempts())
+	}
+}
+
+func mustNewRequest(t *testing.T, method, url string) *Request {
+	t.Helper()
+	req, err := NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return req
+}
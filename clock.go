@@ -0,0 +1,190 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates timers for a Client. Replacing it, for
+// example with a FakeClock, lets tests run retry scenarios with long waits
+// instantly. Contexts, including those bounding attempts with an attempt
+// timeout, still expire in real time, so the time left before the deadline
+// of the request context is always measured with the system clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// NewTimer returns a Timer which fires once d has elapsed.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a timer created by a Clock, like time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is delivered when the timer
+	// fires.
+	C() <-chan time.Time
+
+	// Stop prevents the timer from firing. It returns false if the timer
+	// has already fired or been stopped.
+	Stop() bool
+}
+
+// realClock is the default Clock, which follows the system time.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return timeNow() }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+// clock returns the Clock of the client.
+func (c *Client) clock() Clock {
+	if c.Clock != nil {
+		return c.Clock
+	}
+	return realClock{}
+}
+
+// clockFromContext returns the Clock of the client making the attempt
+// described by ctx.
+func clockFromContext(ctx context.Context) Clock {
+	if state := attemptStateFromContext(ctx); state != nil && state.clock != nil {
+		return state.clock
+	}
+	return realClock{}
+}
+
+// FakeClock is a Clock for tests whose time only moves when told to. By
+// default, time moves when Advance is called. In auto-advance mode, time
+// instead jumps forward whenever a timer is created, so that the timer fires
+// at once and a whole retry scenario runs without waiting.
+//
+// A FakeClock is safe for concurrent use.
+type FakeClock struct {
+	mu          sync.Mutex
+	cond        *sync.Cond
+	now         time.Time
+	autoAdvance bool
+	timers      []*fakeTimer
+	waits       []time.Duration
+}
+
+// NewFakeClock returns a FakeClock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer returns a Timer which fires once the clock has advanced by d.
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	c.waits = append(c.waits, d)
+	if c.autoAdvance && d > 0 {
+		c.now = t.at
+	}
+	if !t.at.After(c.now) {
+		t.fire(c.now)
+	} else {
+		c.timers = append(c.timers, t)
+	}
+	c.cond.Broadcast()
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers which become due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+		} else {
+			t.fire(c.now)
+		}
+	}
+	c.timers = pending
+}
+
+// SetAutoAdvance turns auto-advance mode on or off.
+func (c *FakeClock) SetAutoAdvance(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.autoAdvance = on
+}
+
+// BlockUntil waits until at least n timers are waiting to fire, so that a
+// test can Advance the clock once the code under test is sleeping.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+// Waits returns the durations of every timer created so far, in order. For
+// a Client, these are the waits between attempts.
+func (c *FakeClock) Waits() []time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Duration(nil), c.waits...)
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	c     chan time.Time
+	done  bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.c }
+
+func (t *fakeTimer) Stop() bool {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if t.done {
+		return false
+	}
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			break
+		}
+	}
+	t.done = true
+	return true
+}
+
+// fire delivers now on the channel of the timer. The clock must be locked.
+func (t *fakeTimer) fire(now time.Time) {
+	t.done = true
+	t.c <- now
+}
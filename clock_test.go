@@ -0,0 +1,135 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	a := clock.NewTimer(2 * time.Second)
+	b := clock.NewTimer(time.Second)
+	stopped := clock.NewTimer(time.Second)
+	if !stopped.Stop() || stopped.Stop() {
+		t.Fatalf("expected only the first Stop to succeed")
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case now := <-b.C():
+		if !now.Equal(start.Add(time.Second)) {
+			t.Fatalf("unexpected firing time %s", now)
+		}
+	default:
+		t.Fatalf("expected the 1s timer to fire")
+	}
+	select {
+	case <-a.C():
+		t.Fatalf("expected the 2s timer not to fire yet")
+	case <-stopped.C():
+		t.Fatalf("expected the stopped timer not to fire")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	<-a.C()
+	if a.Stop() {
+		t.Fatalf("expected Stop to fail on a fired timer")
+	}
+	if !clock.Now().Equal(start.Add(2 * time.Second)) {
+		t.Fatalf("unexpected time %s", clock.Now())
+	}
+
+	// A timer which is already due fires at once.
+	<-clock.NewTimer(0).C()
+
+	clock.SetAutoAdvance(true)
+	<-clock.NewTimer(time.Hour).C()
+	if !clock.Now().Equal(start.Add(time.Hour + 2*time.Second)) {
+		t.Fatalf("expected auto-advance to move the clock, got %s", clock.Now())
+	}
+
+	want := []time.Duration{2 * time.Second, time.Second, time.Second, 0, time.Hour}
+	if got := clock.Waits(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected waits %v, got %v", want, got)
+	}
+}
+
+func TestClient_Clock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.SetAutoAdvance(true)
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&requests, 1) {
+		case 1:
+			// The date is interpreted with the client's clock.
+			w.Header().Set("Retry-After", clock.Now().Add(time.Hour).Format(time.RFC1123))
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2, 3:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.Clock = clock
+	client.RetryWaitMin = 10 * time.Second
+	client.RetryWaitMax = time.Minute
+
+	start := time.Now()
+	_, info, err := client.DoWithInfo(mustNewRequest(t, "GET", ts.URL))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the fake clock to skip the waits, took %s", elapsed)
+	}
+
+	want := []time.Duration{time.Hour, 20 * time.Second, 40 * time.Second}
+	if got := clock.Waits(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected waits %v, got %v", want, got)
+	}
+	if got := info.Attempts[3].Start.Sub(info.Attempts[0].Start); got != time.Hour+time.Minute {
+		t.Fatalf("expected the attempts to be timed with the fake clock, got %s", got)
+	}
+
+	// Without auto-advance, the client sleeps until the clock is advanced.
+	clock.SetAutoAdvance(false)
+	var manual int32
+	ts2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&manual, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts2.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := client.Get(ts2.URL)
+		done <- err
+	}()
+	clock.BlockUntil(1)
+	select {
+	case <-done:
+		t.Fatalf("expected the client to wait for the clock")
+	case <-time.After(10 * time.Millisecond):
+	}
+	clock.Advance(10 * time.Second)
+	if err := <-done; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
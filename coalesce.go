@@ -0,0 +1,223 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// defaultCoalesceBodyLimit is the default Coalescer.MaxBodySize.
+const defaultCoalesceBodyLimit = 1 << 20
+
+// Coalescer makes concurrent identical requests of a Client share a single
+// execution, retries included, so that many callers asking for the same
+// resource at the same time, such as when a cache expires, don't each send
+// their own requests. Only requests with idempotent methods and without a
+// body are coalesced, and not those with retry settings of their own or a
+// response handler, which the shared execution couldn't honor for each
+// caller. Requests are identical when they have the same method, URL and
+// values of the headers listed in Headers.
+//
+// Each caller gets a response of its own, whose body is buffered in memory.
+// Should the body be larger than MaxBodySize, the first caller to get the
+// response reads its body as it streams in, and the others make their
+// requests on their own instead. A caller whose context is canceled stops
+// waiting for the shared execution, which is only canceled once every caller
+// waiting for it has given up. The shared execution keeps the values of the
+// context of the first caller, but not its deadline.
+//
+// A Coalescer is safe for concurrent use. Its fields must not be changed
+// once it is in use.
+type Coalescer struct {
+	// Headers are the names of the request headers which must have the same
+	// values for requests to be coalesced, such as "Authorization" or
+	// "Accept". Other headers are ignored, and the shared execution sends
+	// those of the first caller.
+	Headers []string
+
+	// MaxBodySize is the size of the largest response body which is
+	// shared. It defaults to 1MB.
+	MaxBodySize int64
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// coalescedCall is the shared execution of identical requests.
+type coalescedCall struct {
+	// waiters is the number of callers still waiting for the call, and
+	// cancel cancels it. stream is the response whose body was too large
+	// to be shared, until a caller takes it. They are guarded by the mutex
+	// of the Coalescer.
+	waiters int
+	cancel  context.CancelFunc
+	stream  *http.Response
+
+	// done is closed once the fields below are set.
+	done     chan struct{}
+	resp     *http.Response
+	body     []byte
+	info     *RetryInfo
+	err      error
+	tooLarge bool
+}
+
+func (co *Coalescer) maxBodySize() int64 {
+	if co.MaxBodySize > 0 {
+		return co.MaxBodySize
+	}
+	return defaultCoalesceBodyLimit
+}
+
+// key returns the key identifying requests identical to req, and whether
+// req can be coalesced at all.
+func (co *Coalescer) key(req *Request) (string, bool) {
+	if !isIdempotentMethod(req.Method) || req.body != nil {
+		return "", false
+	}
+	if !req.overrides.isZero() || req.responseHandler != nil {
+		return "", false
+	}
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteByte(' ')
+	b.WriteString(req.URL.String())
+	for _, name := range co.Headers {
+		b.WriteByte('\n')
+		b.WriteString(http.CanonicalHeaderKey(name))
+		for _, v := range req.Header.Values(name) {
+			b.WriteByte('\x00')
+			b.WriteString(v)
+		}
+	}
+	return b.String(), true
+}
+
+// doCoalesced makes the request like do, sharing the execution with
+// identical concurrent requests if the client has a Coalescer.
+func (c *Client) doCoalesced(req *Request) (*http.Response, *RetryInfo, error) {
+	co := c.Coalesce
+	if co == nil {
+		return c.do(req)
+	}
+	key, ok := co.key(req)
+	if !ok {
+		return c.do(req)
+	}
+
+	co.mu.Lock()
+	call, ok := co.calls[key]
+	if ok {
+		call.waiters++
+	} else {
+		ctx, cancel := context.WithCancel(context.WithoutCancel(req.Context()))
+		call = &coalescedCall{waiters: 1, cancel: cancel, done: make(chan struct{})}
+		if co.calls == nil {
+			co.calls = make(map[string]*coalescedCall)
+		}
+		co.calls[key] = call
+		go c.runCoalesced(co, key, call, req.WithContext(ctx))
+	}
+	co.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-req.Context().Done():
+		co.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nobody is waiting for the call anymore, so later requests
+			// must not join it.
+			call.cancel()
+			if co.calls[key] == call {
+				delete(co.calls, key)
+			}
+			if call.stream != nil {
+				call.stream.Body.Close()
+				call.stream = nil
+			}
+		}
+		co.mu.Unlock()
+		return nil, &RetryInfo{}, req.Context().Err()
+	}
+
+	info := &RetryInfo{Attempts: append([]Attempt(nil), call.info.Attempts...)}
+	if call.tooLarge {
+		co.mu.Lock()
+		resp := call.stream
+		call.stream = nil
+		co.mu.Unlock()
+		if resp == nil {
+			return c.do(req)
+		}
+		return resp, info, call.err
+	}
+	if call.resp == nil {
+		return nil, info, call.err
+	}
+	resp := *call.resp
+	resp.Header = call.resp.Header.Clone()
+	resp.Trailer = call.resp.Trailer.Clone()
+	resp.Body = io.NopCloser(bytes.NewReader(call.body))
+	return &resp, info, call.err
+}
+
+// runCoalesced makes the request of a coalesced call, and buffers the
+// response body for the callers. A body too large to be buffered is left to
+// stream to the first caller instead.
+func (c *Client) runCoalesced(co *Coalescer, key string, call *coalescedCall, req *Request) {
+	resp, info, err := c.do(req)
+	var stream *http.Response
+	if resp != nil {
+		limit := co.maxBodySize()
+		body, readErr := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+		switch {
+		case readErr != nil:
+			resp.Body.Close()
+			if err == nil {
+				resp, err = nil, readErr
+			}
+		case int64(len(body)) > limit:
+			call.tooLarge = true
+			s := *resp
+			s.Body = &cancelOnClose{
+				ReadCloser: struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body},
+				cancel: call.cancel,
+			}
+			stream = &s
+			body = nil
+		default:
+			resp.Body.Close()
+		}
+		call.body = body
+	}
+	call.resp, call.info, call.err = resp, info, err
+
+	co.mu.Lock()
+	if co.calls[key] == call {
+		delete(co.calls, key)
+	}
+	streaming := stream != nil && call.waiters > 0
+	if streaming {
+		// The call is canceled once the caller taking the response closes
+		// its body.
+		call.stream = stream
+	}
+	co.mu.Unlock()
+	if !streaming {
+		if stream != nil {
+			stream.Body.Close()
+		}
+		call.cancel()
+	}
+	close(call.done)
+}
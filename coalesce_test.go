@@ -0,0 +1,259 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitForWaiters waits until n callers wait for coalesced calls of co.
+func waitForWaiters(t *testing.T, co *Coalescer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		co.mu.Lock()
+		waiters := 0
+		for _, call := range co.calls {
+			waiters += call.waiters
+		}
+		co.mu.Unlock()
+		if waiters == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d waiters, got %d", n, waiters)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClient_Coalesce(t *testing.T) {
+	var requests, plain int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		// The first attempt of the coalesced requests fails, and its retry
+		// is shared too.
+		if r.Header.Get("Accept") == "text/plain" && atomic.AddInt32(&plain, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		<-release
+		w.Header().Set("X-Accept", r.Header.Get("Accept"))
+		io.WriteString(w, "hello")
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.Coalesce = &Coalescer{Headers: []string{"Accept"}}
+
+	type result struct {
+		accept string
+		body   string
+		info   *RetryInfo
+		err    error
+	}
+	results := make(chan result, 6)
+	get := func(accept string) {
+		req := mustNewRequest(t, "GET", ts.URL)
+		req.Header.Set("Accept", accept)
+		resp, info, err := client.DoWithInfo(req)
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		results <- result{accept: resp.Header.Get("X-Accept"), body: string(body), info: info, err: err}
+	}
+	for i := 0; i < 5; i++ {
+		go get("text/plain")
+	}
+	go get("application/json")
+
+	waitForWaiters(t, client.Coalesce, 6)
+	close(release)
+
+	accepts := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("err: %v", r.err)
+		}
+		if r.body != "hello" {
+			t.Fatalf("expected every caller to read the whole body, got %q", r.body)
+		}
+		accepts[r.accept]++
+		if r.accept == "text/plain" && len(r.info.Attempts) != 2 {
+			t.Fatalf("expected the shared execution to be retried, got %d attempts", len(r.info.Attempts))
+		}
+	}
+	if accepts["text/plain"] != 5 || accepts["application/json"] != 1 {
+		t.Fatalf("expected requests with different headers not to be coalesced, got %v", accepts)
+	}
+	if got := atomic.LoadInt32(&requests); got != 3 {
+		t.Fatalf("expected 3 requests, got %d", got)
+	}
+
+	// Once the execution is over, requests start a new one.
+	if _, err := client.Get(ts.URL); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 4 {
+		t.Fatalf("expected 4 requests, got %d", got)
+	}
+}
+
+func TestClient_Coalesce_cancel(t *testing.T) {
+	release := make(chan struct{})
+	canceled := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+			io.WriteString(w, "hello")
+		case <-r.Context().Done():
+			close(canceled)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.Coalesce = &Coalescer{}
+
+	do := func(ctx context.Context, errs chan<- error) {
+		req := mustNewRequest(t, "GET", ts.URL).WithContext(ctx)
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		errs <- err
+	}
+
+	// A caller giving up doesn't cancel the execution shared with others.
+	first, cancelFirst := context.WithCancel(context.Background())
+	firstErr, secondErr := make(chan error, 1), make(chan error, 1)
+	go do(first, firstErr)
+	go do(context.Background(), secondErr)
+	waitForWaiters(t, client.Coalesce, 2)
+	cancelFirst()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the first caller to give up, got %v", err)
+	}
+	close(release)
+	if err := <-secondErr; err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Once every caller has given up, the execution is canceled.
+	release = make(chan struct{})
+	var wg sync.WaitGroup
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do(ctx, errs)
+		}()
+	}
+	waitForWaiters(t, client.Coalesce, 2)
+	cancel()
+	wg.Wait()
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the shared execution to be canceled")
+	}
+}
+
+func TestClient_Coalesce_largeBody(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			<-release
+		}
+		io.WriteString(w, "hello world")
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.Coalesce = &Coalescer{MaxBodySize: 5}
+
+	bodies := make(chan string, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			resp, err := client.Get(ts.URL)
+			if err != nil {
+				bodies <- err.Error()
+				return
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			bodies <- string(body)
+		}()
+	}
+	waitForWaiters(t, client.Coalesce, 3)
+	close(release)
+
+	// The first caller reads the response of the shared execution, and the
+	// others make their own requests instead.
+	for i := 0; i < 3; i++ {
+		if body := <-bodies; body != "hello world" {
+			t.Fatalf("expected the whole body, got %q", body)
+		}
+	}
+	if got := atomic.LoadInt32(&requests); got != 3 {
+		t.Fatalf("expected 3 requests, got %d", got)
+	}
+}
+
+func TestCoalescer_key(t *testing.T) {
+	co := &Coalescer{Headers: []string{"Accept"}}
+	key := func(req *Request) string {
+		t.Helper()
+		k, ok := co.key(req)
+		if !ok {
+			t.Fatalf("expected %s %s to be coalesced", req.Method, req.URL)
+		}
+		return k
+	}
+
+	get := mustNewRequest(t, "GET", "http://example.com/a")
+	if key(get) != key(mustNewRequest(t, "GET", "http://example.com/a")) {
+		t.Fatalf("expected identical requests to have the same key")
+	}
+	json := mustNewRequest(t, "GET", "http://example.com/a")
+	json.Header.Set("Accept", "application/json")
+	if key(get) == key(json) {
+		t.Fatalf("expected requests with different headers to have different keys")
+	}
+
+	for name, fn := range map[string]func(*Request){
+		"body":                  func(r *Request) { r.body = func() (io.Reader, error) { return nil, nil } },
+		"non-idempotent method": func(r *Request) { r.Method = "POST" },
+		"retry max":             func(r *Request) { r.DisableRetries() },
+		"check retry":           func(r *Request) { r.SetCheckRetry(DefaultRetryPolicy) },
+		"backoff":               func(r *Request) { r.SetBackoff(DefaultBackoff) },
+		"attempt timeout":       func(r *Request) { r.SetAttemptTimeout(time.Second) },
+		"prepare retry":         func(r *Request) { r.SetPrepareRetry(func(*http.Request) error { return nil }) },
+		"response handler":      func(r *Request) { r.SetResponseHandler(func(*http.Response) error { return nil }) },
+	} {
+		req := mustNewRequest(t, "GET", "http://example.com/a")
+		fn(req)
+		if _, ok := co.key(req); ok {
+			t.Fatalf("expected a request with a %s not to be coalesced", name)
+		}
+	}
+}
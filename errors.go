@@ -0,0 +1,169 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrRetriesExhausted is reported by a RetryError when CheckRetry still
+	// wanted to retry but the configured number of retries was used up. A
+	// CheckRetry or CheckRetryV2 enforcing a limit of its own returns it to
+	// stop retrying a request which failed, which Client.Do then reports
+	// the same way.
+	ErrRetriesExhausted = errors.New("retries exhausted")
+
+	// ErrPrepareRetryFailed is reported by a RetryError when the PrepareRetry
+	// function returned an error before a retry could be attempted.
+	ErrPrepareRetryFailed = errors.New("prepare retry failed")
+
+	// ErrCheckRetryAborted is reported by a RetryError when CheckRetry
+	// decided not to retry a request that failed.
+	ErrCheckRetryAborted = errors.New("check retry aborted")
+
+	// ErrWouldExceedDeadline is reported by a RetryError when the request
+	// was given up before its context expired, because the backoff and the
+	// next attempt would not have fit before the context deadline.
+	ErrWouldExceedDeadline = errors.New("retry would exceed context deadline")
+
+	// ErrMaxElapsedTime is reported by a RetryError when the request was
+	// given up because retrying would have taken longer than the
+	// MaxElapsedTime of the client.
+	ErrMaxElapsedTime = errors.New("retry would exceed max elapsed time")
+
+	// ErrCircuitOpen is matched by a *CircuitOpenError, which is returned
+	// when the circuit of the CircuitBreaker of the client rejects an
+	// attempt.
+	ErrCircuitOpen = errors.New("circuit open")
+
+	// ErrRetryBudgetExhausted is reported by a RetryError when the request
+	// was given up because the RetryBudget of the client didn't allow
+	// another retry.
+	ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+	// ErrThrottled is matched by a *ThrottledError, which is returned when
+	// the AdaptiveThrottle of the client rejects an attempt.
+	ErrThrottled = errors.New("throttled")
+)
+
+// CircuitOpenError is the error of a request rejected by an open circuit of
+// a CircuitBreaker. Client.Do returns it when the first attempt is rejected,
+// and reports it as the Kind of a RetryError when a retry is. It matches
+// ErrCircuitOpen with errors.Is.
+type CircuitOpenError struct {
+	// Key is the key of the circuit.
+	Key string
+
+	// RetryAt is when the circuit will next let a probe through.
+	RetryAt time.Time
+}
+
+// Error implements the error interface.
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit %s open until %s", e.Key, e.RetryAt.Format(time.RFC3339))
+}
+
+// Is reports whether target is ErrCircuitOpen.
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// ThrottledError is the error of a request rejected locally by an
+// AdaptiveThrottle. Client.Do returns it when the first attempt is rejected,
+// and reports it as the Kind of a RetryError when a retry is. It matches
+// ErrThrottled with errors.Is.
+type ThrottledError struct {
+	// Key is the key of the counts of the request.
+	Key string
+
+	// Probability is the probability with which the attempt was rejected.
+	Probability float64
+}
+
+// Error implements the error interface.
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("%s throttled locally (rejection probability %.2f)", e.Key, e.Probability)
+}
+
+// Is reports whether target is ErrThrottled.
+func (e *ThrottledError) Is(target error) bool {
+	return target == ErrThrottled
+}
+
+// ErrAttemptTimeout is the error of an attempt which was cut short by the
+// attempt timeout (see Client.AttemptTimeout). Unlike the expiry of the
+// request context, it only ends the attempt, which is retried like any other
+// timeout. It is wrapped in a *url.Error, and implements net.Error with a
+// Timeout method returning true.
+var ErrAttemptTimeout error = attemptTimeoutError{}
+
+// attemptTimeoutError is the type of ErrAttemptTimeout.
+type attemptTimeoutError struct{}
+
+func (attemptTimeoutError) Error() string   { return "attempt timed out" }
+func (attemptTimeoutError) Timeout() bool   { return true }
+func (attemptTimeoutError) Temporary() bool { return true }
+
+// retriesExhausted reports whether a retry policy decided to stop retrying
+// a failure because it used up the retries it allows.
+func retriesExhausted(decision RetryDecision) bool {
+	return !decision.Retry && errors.Is(decision.Err, ErrRetriesExhausted)
+}
+
+// RetryError is returned by Client.Do when it gives up on a request and no
+// ErrorHandler is configured. It records every attempt that was made so that
+// callers can inspect what happened without parsing the error string.
+//
+// The sentinel error describing why Client.Do gave up, such as
+// ErrRetriesExhausted or ErrCheckRetryAborted, can be matched against it
+// with errors.Is, as can the underlying error of the last attempt.
+type RetryError struct {
+	// Method and URL identify the request. The URL has any password
+	// redacted.
+	Method string
+	URL    string
+
+	// Attempts holds the history of every attempt, in order.
+	Attempts []Attempt
+
+	// Err is the error that caused Client.Do to give up, which may be nil if
+	// CheckRetry considered the request a failure without saying why.
+	Err error
+
+	// Kind is the sentinel error describing why Client.Do gave up.
+	Kind error
+
+	// Reason is the reason given by a CheckRetryV2 policy for its last
+	// decision, if any.
+	Reason string
+}
+
+// Error implements the error interface.
+func (e *RetryError) Error() string {
+	msg := fmt.Sprintf("%s %s giving up after %d attempt(s)",
+		e.Method, e.URL, len(e.Attempts))
+	if e.Reason != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Reason)
+	}
+	if e.Err == nil {
+		return msg
+	}
+	return fmt.Sprintf("%s: %s", msg, e.Err)
+}
+
+// Unwrap returns the underlying error and the sentinel kind, allowing both
+// to be matched with errors.Is and errors.As.
+func (e *RetryError) Unwrap() []error {
+	errs := make([]error, 0, 2)
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	return errs
+}
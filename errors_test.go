@@ -0,0 +1,251 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryError_Error(t *testing.T) {
+	err := &RetryError{
+		Method:   "GET",
+		URL:      "http://example.com",
+		Attempts: []Attempt{{Number: 1}, {Number: 2}},
+	}
+	if got, want := err.Error(), "GET http://example.com giving up after 2 attempt(s)"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	err.Err = errors.New("boom")
+	if got, want := err.Error(), "GET http://example.com giving up after 2 attempt(s): boom"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	err.Reason = "upstream overloaded"
+	if got, want := err.Error(), "GET http://example.com giving up after 2 attempt(s) (upstream overloaded): boom"; got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestClient_Do_RetryError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	prepareErr := errors.New("prepare failed")
+	tests := []struct {
+		name     string
+		cr       CheckRetry
+		prepare  PrepareRetry
+		attempts int
+		kind     error
+		cause    error
+	}{
+		{
+			name:     "exhausted",
+			cr:       ErrorPropagatedRetryPolicy,
+			attempts: 3,
+			kind:     ErrRetriesExhausted,
+		},
+		{
+			name: "check_retry_aborted",
+			cr: func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+				return false, context.Canceled
+			},
+			attempts: 1,
+			kind:     ErrCheckRetryAborted,
+			cause:    context.Canceled,
+		},
+		{
+			name: "prepare_retry_failed",
+			cr:   DefaultRetryPolicy,
+			prepare: func(*http.Request) error {
+				return prepareErr
+			},
+			attempts: 1,
+			kind:     ErrPrepareRetryFailed,
+			cause:    prepareErr,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient()
+			client.RetryWaitMin = 10 * time.Millisecond
+			client.RetryWaitMax = 10 * time.Millisecond
+			client.RetryMax = 2
+			client.CheckRetry = tt.cr
+			client.PrepareRetry = tt.prepare
+
+			_, err := client.Get(ts.URL)
+
+			var retryErr *RetryError
+			if !errors.As(err, &retryErr) {
+				t.Fatalf("expected *RetryError, got %#v", err)
+			}
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("expected %v, got %v", tt.kind, retryErr.Kind)
+			}
+			if tt.cause != nil && !errors.Is(err, tt.cause) {
+				t.Fatalf("expected %v, got %v", tt.cause, retryErr.Err)
+			}
+			if len(retryErr.Attempts) != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, len(retryErr.Attempts))
+			}
+			for i, a := range retryErr.Attempts {
+				if a.Number != i+1 {
+					t.Fatalf("expected attempt number %d, got %d", i+1, a.Number)
+				}
+				if a.StatusCode != http.StatusServiceUnavailable {
+					t.Fatalf("expected status 503, got %d", a.StatusCode)
+				}
+				if a.Start.IsZero() || a.Duration <= 0 {
+					t.Fatalf("expected timing to be recorded: %#v", a)
+				}
+				last := i == len(retryErr.Attempts)-1
+				if tt.kind == ErrRetriesExhausted && last != (a.Wait == 0) {
+					t.Fatalf("unexpected wait %s for attempt %d", a.Wait, a.Number)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_Do_RetryErrorTransport(t *testing.T) {
+	client := NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.RetryMax = 1
+
+	// Nothing is listening on this port, so every attempt fails in transport.
+	_, err := client.Get("http://127.0.0.1:1/")
+
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("expected *RetryError, got %#v", err)
+	}
+	if !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("expected ErrRetriesExhausted, got %v", retryErr.Kind)
+	}
+	for _, a := range retryErr.Attempts {
+		if a.Err == nil || a.StatusCode != 0 || !a.ShouldRetry {
+			t.Fatalf("expected a retryable transport error, got %#v", a)
+		}
+	}
+}
+
+func TestClient_Do_WouldExceedDeadline(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryMax = 3
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := NewRequestWithContext(ctx, "GET", ts.URL, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	start := time.Now()
+	_, err = client.Do(req)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected to give up at once, took %s", elapsed)
+	}
+
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("expected *RetryError, got %#v", err)
+	}
+	if !errors.Is(err, ErrWouldExceedDeadline) || errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected ErrWouldExceedDeadline, got %v", err)
+	}
+	if len(retryErr.Attempts) != 1 || retryErr.Attempts[0].Wait != 0 {
+		t.Fatalf("expected a single attempt without wait, got %#v", retryErr.Attempts)
+	}
+	if retryErr.Err == nil || !strings.Contains(retryErr.Err.Error(), "503") {
+		t.Fatalf("expected the last status to be reported, got %v", retryErr.Err)
+	}
+
+	// Retries which fit before the deadline are still made.
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.Backoff = LinearJitterBackoff
+	_, err = client.Do(req)
+	if !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("expected ErrRetriesExhausted, got %v", err)
+	}
+}
+
+func TestClient_Do_MaxElapsedTime(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	// Every reading of the clock advances it by 10ms.
+	var ticks int64
+	base := time.Now()
+	timeNow = func() time.Time {
+		return base.Add(time.Duration(atomic.AddInt64(&ticks, 1)) * 10 * time.Millisecond)
+	}
+	t.Cleanup(func() { timeNow = time.Now })
+
+	tests := []struct {
+		name     string
+		retryMax int
+		override time.Duration
+		kind     error
+	}{
+		{"bounded_by_time", 100, 0, ErrMaxElapsedTime},
+		{"bounded_by_retries", 2, 0, ErrRetriesExhausted},
+		{"unlimited", -1, 0, ErrMaxElapsedTime},
+		{"request_override", -1, time.Second, ErrMaxElapsedTime},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewClient()
+			client.RetryMax = tt.retryMax
+			client.RetryWaitMin = time.Microsecond
+			client.RetryWaitMax = time.Microsecond
+			client.MaxElapsedTime = 200 * time.Millisecond
+
+			req := mustNewRequest(t, "GET", ts.URL)
+			if tt.override > 0 {
+				req.SetMaxElapsedTime(tt.override)
+			}
+			_, info, err := client.DoWithInfo(req)
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("expected %v, got %v", tt.kind, err)
+			}
+
+			limit := client.MaxElapsedTime
+			if tt.override > 0 {
+				limit = tt.override
+			}
+			last := info.Attempts[len(info.Attempts)-1]
+			if elapsed := last.Start.Sub(info.Attempts[0].Start); elapsed > limit {
+				t.Fatalf("expected the last attempt to start within %s, started after %s", limit, elapsed)
+			}
+			if tt.kind == ErrRetriesExhausted && info.NumAttempts() != tt.retryMax+1 {
+				t.Fatalf("expected %d attempts, got %d", tt.retryMax+1, info.NumAttempts())
+			}
+			if tt.kind == ErrMaxElapsedTime && info.NumAttempts() < 3 {
+				t.Fatalf("expected several attempts, got %d", info.NumAttempts())
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// hedgeSamples is the number of latencies per host from which a HedgePolicy
+// derives its delay.
+const hedgeSamples = 100
+
+// HedgePolicy makes a Client send extra copies of an attempt when the
+// response is slow to come, and use whichever response comes first, which
+// cuts the tail latency of requests to upstreams with occasional slow
+// responses. Only requests with idempotent methods are hedged. Each copy
+// gets its body from the ReaderFunc of the request, like a retry, so the
+// readers it returns must be independent. A body given as an io.ReadSeeker,
+// which the attempts share, is read once instead.
+//
+// Once the hedge delay has elapsed without a successful response, another
+// copy of the request is sent, up to MaxHedges copies in addition to the
+// original, each after a further delay. The first response which the retry
+// policy in effect for the attempt wouldn't retry wins, and the other copies
+// are canceled, their bodies drained and closed. The policy is thus asked
+// about every response of a copy, and once more about the winner. If every
+// copy fails, the last failure is the outcome of the attempt, which is then
+// retried as usual.
+//
+// The delay is either fixed, or a percentile of the latencies recently
+// observed for the host of the request. Delays are measured with the system
+// clock, since they race with actual requests.
+//
+// A HedgePolicy is safe for concurrent use. Its fields must not be changed
+// once it is in use.
+type HedgePolicy struct {
+	// Delay is how long to wait for a response before sending another copy
+	// of the request. It is used until enough latencies were observed when
+	// Percentile is set. Requests aren't hedged when it is zero.
+	Delay time.Duration
+
+	// Percentile, if positive, such as 0.95, derives the delay from the
+	// latencies of the last responses from the host of the request: the
+	// delay is the latency which that fraction of them didn't exceed.
+	Percentile float64
+
+	// MinSamples is the number of latencies which must have been observed
+	// for a host before Percentile is used. It defaults to 10.
+	MinSamples int
+
+	// MaxHedges is the number of copies sent in addition to the original
+	// request. It defaults to 1.
+	MaxHedges int
+
+	mu        sync.Mutex
+	latencies map[string]*latencySamples
+}
+
+// NewHedgePolicy returns a HedgePolicy sending one extra copy of a request
+// after the given delay.
+func NewHedgePolicy(delay time.Duration) *HedgePolicy {
+	return &HedgePolicy{Delay: delay, MaxHedges: 1}
+}
+
+// latencySamples is a ring buffer of the last latencies of a host.
+type latencySamples struct {
+	samples []time.Duration
+	next    int
+}
+
+func (h *HedgePolicy) maxHedges() int {
+	if h.MaxHedges > 0 {
+		return h.MaxHedges
+	}
+	return 1
+}
+
+func (h *HedgePolicy) minSamples() int {
+	if h.MinSamples > 0 {
+		return h.MinSamples
+	}
+	return 10
+}
+
+// delay returns the hedge delay of requests to host.
+func (h *HedgePolicy) delay(host string) time.Duration {
+	if h.Percentile <= 0 {
+		return h.Delay
+	}
+
+	h.mu.Lock()
+	s, ok := h.latencies[host]
+	if !ok || len(s.samples) < h.minSamples() {
+		h.mu.Unlock()
+		return h.Delay
+	}
+	sorted := append([]time.Duration(nil), s.samples...)
+	h.mu.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(math.Ceil(math.Min(h.Percentile, 1)*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// observe records the latency of a response from host.
+func (h *HedgePolicy) observe(host string, latency time.Duration) {
+	if h.Percentile <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.latencies == nil {
+		h.latencies = make(map[string]*latencySamples)
+	}
+	s, ok := h.latencies[host]
+	if !ok {
+		s = &latencySamples{}
+		h.latencies[host] = s
+	}
+	if len(s.samples) < hedgeSamples {
+		s.samples = append(s.samples, latency)
+		return
+	}
+	s.samples[s.next] = latency
+	s.next = (s.next + 1) % hedgeSamples
+}
+
+// hedgeResult is the outcome of one copy of a hedged attempt.
+type hedgeResult struct {
+	resp   *http.Response
+	err    error
+	copy   int
+	cancel context.CancelFunc
+}
+
+// succeeded reports whether the copy got a response which the retry policy
+// of the attempt made with req wouldn't retry, which wins the race.
+func (r hedgeResult) succeeded(settings *retrySettings, req *http.Request, attempt AttemptInfo) bool {
+	if r.err != nil {
+		return false
+	}
+	decision := settings.check(req.Context(), req, r.resp, nil, attempt)
+	return !decision.Retry && !retriesExhausted(decision)
+}
+
+// discard cancels the copy and drains and closes its body, if any.
+func (r hedgeResult) discard() {
+	if r.resp != nil && r.resp.Body != nil {
+		io.Copy(io.Discard, io.LimitReader(r.resp.Body, respReadLimit))
+		r.resp.Body.Close()
+	}
+	r.cancel()
+}
+
+// sendAttempt makes the request of attempt number i, which is req for the
+// attempt, hedging it if the HedgePolicy of the client applies. The copies
+// are judged with the retry settings of the attempt.
+func (c *Client) sendAttempt(logger interface{}, req *Request, attemptReq *http.Request, i int, settings *retrySettings, attempt AttemptInfo) (*http.Response, error) {
+	h := c.Hedge
+	if h == nil || !isIdempotentMethod(attemptReq.Method) {
+		return c.HTTPClient.Do(attemptReq)
+	}
+	host := attemptReq.URL.Host
+	delay := h.delay(host)
+	if delay <= 0 {
+		return c.HTTPClient.Do(attemptReq)
+	}
+
+	// The copies are sent concurrently, so they can't share a reader. Each
+	// copy gets one of its own from the ReaderFunc of the request, except
+	// when it rewinds a single io.ReadSeeker: the body is then read once.
+	newBody := req.body
+	if _, ok := attemptReq.Body.(*rewoundSeeker); ok {
+		body, err := io.ReadAll(attemptReq.Body)
+		if err != nil {
+			return nil, err
+		}
+		newBody = func() (io.Reader, error) { return bytes.NewReader(body), nil }
+		attemptReq, _ = hedgeRequest(attemptReq, newBody)
+	}
+
+	results := make(chan hedgeResult, h.maxHedges()+1)
+	var cancels []context.CancelFunc
+	send := func(r *http.Request, hedged bool) {
+		ctx, cancel := context.WithCancel(r.Context())
+		n := len(cancels)
+		cancels = append(cancels, cancel)
+		r = r.WithContext(ctx)
+		var err error
+		if hedged {
+			r, err = hedgeRequest(r, newBody)
+		}
+		go func() {
+			if err != nil {
+				results <- hedgeResult{err: err, copy: n, cancel: cancel}
+				return
+			}
+			start := timeNow()
+			resp, err := c.HTTPClient.Do(r)
+			if err == nil {
+				h.observe(host, timeNow().Sub(start))
+			}
+			results <- hedgeResult{resp: resp, err: err, copy: n, cancel: cancel}
+		}()
+	}
+
+	send(attemptReq, false)
+	sent, inflight := 1, 1
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var outcome hedgeResult
+	var won bool
+	for inflight > 0 && !won {
+		var hedge <-chan time.Time
+		if sent <= h.maxHedges() {
+			hedge = timer.C
+		}
+		select {
+		case <-hedge:
+			copyReq := attemptReq.Clone(attemptReq.Context())
+			c.requestLogHook(logger, copyReq, i)
+			send(copyReq, true)
+			sent++
+			inflight++
+			timer.Reset(delay)
+		case r := <-results:
+			inflight--
+			if outcome.cancel != nil {
+				// Only the last failure is kept as the outcome of the
+				// attempt.
+				if outcome.err == nil {
+					c.responseLogHook(logger, outcome.resp)
+				}
+				outcome.discard()
+			}
+			outcome, won = r, r.succeeded(settings, attemptReq, attempt)
+		}
+	}
+
+	// Cancel the copies still in flight, and discard their responses once
+	// they give up.
+	if inflight > 0 {
+		for n, cancel := range cancels {
+			if n != outcome.copy {
+				cancel()
+			}
+		}
+		go func(n int) {
+			for ; n > 0; n-- {
+				(<-results).discard()
+			}
+		}(inflight)
+	}
+
+	if outcome.err != nil || outcome.resp.Body == nil {
+		outcome.cancel()
+		return outcome.resp, outcome.err
+	}
+	outcome.resp.Body = &cancelOnClose{ReadCloser: outcome.resp.Body, cancel: outcome.cancel}
+	return outcome.resp, nil
+}
+
+// hedgeRequest gives r, a copy of the request of a hedged attempt, a reader
+// of its own of the body of the attempt, if any, obtained from newBody.
+func hedgeRequest(r *http.Request, newBody ReaderFunc) (*http.Request, error) {
+	if newBody == nil {
+		return r, nil
+	}
+	getBody := func() (io.ReadCloser, error) {
+		body, err := newBody()
+		if err != nil {
+			return nil, err
+		}
+		if rc, ok := body.(io.ReadCloser); ok {
+			return rc, nil
+		}
+		return io.NopCloser(body), nil
+	}
+	body, err := getBody()
+	if err != nil {
+		return nil, err
+	}
+	r.Body, r.GetBody = body, getBody
+	return r, nil
+}
@@ -0,0 +1,219 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHedgePolicy_delay(t *testing.T) {
+	h := &HedgePolicy{Delay: time.Second, Percentile: 0.9}
+	for i := 1; i < 10; i++ {
+		h.observe("a", time.Duration(i)*time.Millisecond)
+	}
+	if got := h.delay("a"); got != time.Second {
+		t.Fatalf("expected the fixed delay until enough samples, got %s", got)
+	}
+	h.observe("a", 10*time.Millisecond)
+	if got := h.delay("a"); got != 9*time.Millisecond {
+		t.Fatalf("expected the 90th percentile, got %s", got)
+	}
+	if got := h.delay("b"); got != time.Second {
+		t.Fatalf("expected the fixed delay for another host, got %s", got)
+	}
+
+	// Only the last samples are kept.
+	for i := 0; i < hedgeSamples; i++ {
+		h.observe("a", time.Minute)
+	}
+	if got := h.delay("a"); got != time.Minute {
+		t.Fatalf("expected the old samples to be forgotten, got %s", got)
+	}
+}
+
+func TestClient_Hedge(t *testing.T) {
+	var requests int32
+	canceled := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "payload" {
+			t.Errorf("expected every copy to carry the body, got %q", body)
+		}
+		if atomic.AddInt32(&requests, 1) == 1 {
+			// The original is slow, and canceled once the copy wins.
+			select {
+			case <-r.Context().Done():
+				close(canceled)
+			case <-time.After(10 * time.Second):
+			}
+			return
+		}
+		io.WriteString(w, "hedged")
+	}))
+	defer ts.Close()
+
+	var sent, received int32
+	client := NewClient()
+	client.Hedge = NewHedgePolicy(20 * time.Millisecond)
+	client.RequestLogHook = func(_ Logger, _ *http.Request, i int) {
+		if i != 0 {
+			t.Errorf("expected the copies to be part of the first attempt, got %d", i)
+		}
+		atomic.AddInt32(&sent, 1)
+	}
+	client.ResponseLogHook = func(Logger, *http.Response) {
+		atomic.AddInt32(&received, 1)
+	}
+
+	req, err := NewRequest("PUT", ts.URL, strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, info, err := client.DoWithInfo(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "hedged" {
+		t.Fatalf("expected the response of the copy, got %q, %v", body, err)
+	}
+	if len(info.Attempts) != 1 || sent != 2 || received != 1 {
+		t.Fatalf("expected 1 attempt, 2 copies sent and 1 response, got %d, %d and %d", len(info.Attempts), sent, received)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the original to be canceled")
+	}
+}
+
+func TestClient_Hedge_readerFunc(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != "payload" {
+			t.Errorf("expected every copy to carry the body, got %q", body)
+		}
+		if atomic.AddInt32(&requests, 1) == 1 {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.Hedge = NewHedgePolicy(10 * time.Millisecond)
+
+	// Each copy gets a reader of its own from the ReaderFunc, besides the
+	// one NewRequest reads the length from.
+	var readers int32
+	req, err := NewRequest("PUT", ts.URL, ReaderFunc(func() (io.Reader, error) {
+		atomic.AddInt32(&readers, 1)
+		return strings.NewReader("payload"), nil
+	}))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp.Body.Close()
+	if got := atomic.LoadInt32(&readers); got != 3 {
+		t.Fatalf("expected 3 readers, got %d", got)
+	}
+}
+
+func TestClient_Hedge_failures(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	var received int32
+	client := NewClient()
+	client.RetryMax = 0
+	client.Hedge = &HedgePolicy{Delay: 10 * time.Millisecond, MaxHedges: 1}
+	client.ResponseLogHook = func(Logger, *http.Response) {
+		atomic.AddInt32(&received, 1)
+	}
+
+	// Both copies fail, so the attempt fails, and both responses are
+	// logged.
+	_, info, err := client.DoWithInfo(mustNewRequest(t, "GET", ts.URL))
+	if err == nil || len(info.Attempts) != 1 || info.Attempts[0].StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected the attempt to fail, got %v", err)
+	}
+	if requests != 2 || received != 2 {
+		t.Fatalf("expected 2 requests and 2 responses, got %d and %d", requests, received)
+	}
+}
+
+func TestClient_Hedge_checkRetry(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			time.Sleep(50 * time.Millisecond)
+			io.WriteString(w, "original")
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryMax = 0
+	client.Hedge = NewHedgePolicy(10 * time.Millisecond)
+	client.CheckRetry = func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return true, nil
+		}
+		return DefaultRetryPolicy(ctx, resp, err)
+	}
+
+	// The copy gets a response which the policy of the client retries, so
+	// it doesn't win.
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "original" {
+		t.Fatalf("expected the response of the original, got %q, %v", body, err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("expected 2 requests, got %d", got)
+	}
+}
+
+func TestClient_Hedge_nonIdempotent(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.Hedge = NewHedgePolicy(time.Millisecond)
+	if _, err := client.Post(ts.URL, "text/plain", strings.NewReader("x")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Fatalf("expected a POST not to be hedged, got %d requests", got)
+	}
+}
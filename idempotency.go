@@ -0,0 +1,172 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+)
+
+// defaultIdempotencyKeyHeader is the header defined by the IETF httpapi
+// Idempotency-Key draft, which tells the server it may safely deduplicate
+// repeated requests.
+const defaultIdempotencyKeyHeader = "Idempotency-Key"
+
+// crockfordAlphabet is the base32 alphabet used to encode ULIDs.
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// IdempotencyKeyFunc generates a new, unique idempotency key.
+type IdempotencyKeyFunc func() (string, error)
+
+// UUIDv4IdempotencyKey is an IdempotencyKeyFunc which generates random
+// version 4 UUIDs, as recommended by the Idempotency-Key draft.
+func UUIDv4IdempotencyKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = (b[6] & 0x0f) | 0x40 // version 4
+	b[8] = (b[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// ULIDIdempotencyKey is an IdempotencyKeyFunc which generates ULIDs. Unlike
+// UUIDs, ULIDs sort by the time they were generated.
+func ULIDIdempotencyKey() (string, error) {
+	var b [16]byte
+	ms := uint64(timeNow().UnixMilli())
+	binary.BigEndian.PutUint16(b[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(b[2:6], uint32(ms))
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+
+	// Encode the 128 bits as 26 characters of 5 bits each, most significant
+	// first. The first character only holds the top 3 bits.
+	hi, lo := binary.BigEndian.Uint64(b[0:8]), binary.BigEndian.Uint64(b[8:16])
+	out := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		out[i] = crockfordAlphabet[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out), nil
+}
+
+// idempotencyKeyHeader returns the name of the header carrying idempotency
+// keys for requests made by the client.
+func (c *Client) idempotencyKeyHeader() string {
+	if c.IdempotencyKeyHeader != "" {
+		return c.IdempotencyKeyHeader
+	}
+	return defaultIdempotencyKeyHeader
+}
+
+// setIdempotencyKey generates an idempotency key for req if the client is
+// configured to and req needs one. It returns the key req carries, which is
+// empty if the client doesn't generate keys.
+func (c *Client) setIdempotencyKey(req *Request) (string, error) {
+	if c.IdempotencyKey == nil || isIdempotentMethod(req.Method) {
+		return "", nil
+	}
+
+	header := c.idempotencyKeyHeader()
+	if key := req.Header.Get(header); key != "" {
+		return key, nil
+	}
+	key, err := c.IdempotencyKey()
+	if err != nil {
+		return "", fmt.Errorf("error generating idempotency key: %w", err)
+	}
+
+	// Copy the request and its headers so the caller's http.Request is left
+	// untouched.
+	httpreq := *req.Request
+	httpreq.Header = req.Header.Clone()
+	if httpreq.Header == nil {
+		httpreq.Header = make(http.Header)
+	}
+	httpreq.Header.Set(header, key)
+	req.Request = &httpreq
+	return key, nil
+}
+
+// logKeyvals appends the idempotency key, if any, to keysAndValues for a
+// LeveledLogger.
+func logKeyvals(key string, keysAndValues ...interface{}) []interface{} {
+	if key == "" {
+		return keysAndValues
+	}
+	return append(keysAndValues, "idempotency_key", key)
+}
+
+// logSuffix describes the idempotency key, if any, for a Logger.
+func logSuffix(key string) string {
+	if key == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (idempotency key: %s)", key)
+}
+
+// isIdempotentMethod reports whether requests with the given method can be
+// repeated without changing the outcome, per RFC 9110 section 9.2.2.
+func isIdempotentMethod(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// IdempotentRetryPolicy is like DefaultRetryPolicy, but it won't retry a
+// request with a non-idempotent method such as POST or PATCH if the server
+// may already have processed it. Such requests are only retried when they
+// carry an idempotency key (see Client.IdempotencyKey), or when the attempt
+// failed before the request was written to the connection. Requests with
+// idempotent methods (GET, HEAD, OPTIONS, TRACE, PUT and DELETE) are retried
+// exactly as by DefaultRetryPolicy.
+//
+// Whether the request was written is tracked by Client.Do for each attempt,
+// so a request with a non-idempotent method is never retried when this
+// policy is invoked outside of Client.Do with no response.
+func IdempotentRetryPolicy(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	shouldRetry, checkErr := DefaultRetryPolicy(ctx, resp, err)
+	if !shouldRetry {
+		return false, checkErr
+	}
+	return retrySafe(ctx, resp), nil
+}
+
+// retrySafe reports whether repeating the request of the attempt described
+// by ctx and resp cannot cause it to be processed twice.
+func retrySafe(ctx context.Context, resp *http.Response) bool {
+	state := attemptStateFromContext(ctx)
+
+	var method string
+	var header http.Header
+	keyHeader := defaultIdempotencyKeyHeader
+	switch {
+	case state != nil:
+		method, header, keyHeader = state.req.Method, state.req.Header, state.keyHeader
+	case resp != nil && resp.Request != nil:
+		method, header = resp.Request.Method, resp.Request.Header
+	default:
+		return false
+	}
+
+	if isIdempotentMethod(method) || header.Get(keyHeader) != "" {
+		return true
+	}
+
+	// Getting a response means the server saw the request.
+	if resp != nil {
+		return false
+	}
+	return state != nil && !state.wroteRequest.Load()
+}
@@ -0,0 +1,207 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotentRetryPolicy(t *testing.T) {
+	var requests int32
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	// This server reads the request and then drops the connection without
+	// responding, so the client sees an error after the request was written.
+	dropping := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("err: %v", err)
+			return
+		}
+		conn.Close()
+	}))
+	defer dropping.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		key      string
+		attempts int
+		served   int32
+	}{
+		{"get_500", http.MethodGet, failing.URL, "", 3, 3},
+		{"put_500", http.MethodPut, failing.URL, "", 3, 3},
+		{"post_500", http.MethodPost, failing.URL, "", 1, 1},
+		{"patch_500", http.MethodPatch, failing.URL, "", 1, 1},
+		{"post_500_with_key", http.MethodPost, failing.URL, "abc", 3, 3},
+		{"get_dropped", http.MethodGet, dropping.URL, "", 3, 3},
+		{"post_dropped", http.MethodPost, dropping.URL, "", 1, 1},
+		{"post_dropped_with_key", http.MethodPost, dropping.URL, "abc", 3, 3},
+		// Nothing is listening on this port, so the request is never written.
+		{"post_refused", http.MethodPost, "http://127.0.0.1:1/", "", 3, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+
+			client := NewClient()
+			client.RetryWaitMin = time.Millisecond
+			client.RetryWaitMax = time.Millisecond
+			client.RetryMax = 2
+			client.CheckRetry = IdempotentRetryPolicy
+
+			req, err := NewRequest(tt.method, tt.url, []byte("payload"))
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			if tt.key != "" {
+				req.Header.Set("Idempotency-Key", tt.key)
+			}
+
+			_, info, _ := client.DoWithInfo(req)
+			if info.NumAttempts() != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, info.NumAttempts())
+			}
+			if served := atomic.LoadInt32(&requests); served != tt.served {
+				t.Fatalf("expected the server to see %d requests, got %d", tt.served, served)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyFuncs(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   IdempotencyKeyFunc
+		re   *regexp.Regexp
+	}{
+		{"uuidv4", UUIDv4IdempotencyKey, regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)},
+		{"ulid", ULIDIdempotencyKey, regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen := make(map[string]bool)
+			for i := 0; i < 100; i++ {
+				key, err := tt.fn()
+				if err != nil {
+					t.Fatalf("err: %v", err)
+				}
+				if !tt.re.MatchString(key) {
+					t.Fatalf("malformed key %q", key)
+				}
+				if seen[key] {
+					t.Fatalf("duplicate key %q", key)
+				}
+				seen[key] = true
+			}
+		})
+	}
+
+	// ULIDs generated in later milliseconds sort after earlier ones.
+	testStaticTime(t)
+	early, _ := ULIDIdempotencyKey()
+	timeNow = func() time.Time { return time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC) }
+	late, _ := ULIDIdempotencyKey()
+	if early[:10] >= late[:10] {
+		t.Fatalf("expected %q to sort before %q", early, late)
+	}
+}
+
+func TestClient_IdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get("X-Request-Key"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	buf := new(bytes.Buffer)
+	client := NewClient()
+	client.Logger = log.New(buf, "", 0)
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.RetryMax = 2
+	client.CheckRetry = IdempotentRetryPolicy
+	client.IdempotencyKey = func() (string, error) { return "key-1", nil }
+	client.IdempotencyKeyHeader = "X-Request-Key"
+	client.PrepareRetry = func(req *http.Request) error {
+		// Simulate a signer which rebuilds the headers from scratch.
+		req.Header = http.Header{"Authorization": []string{"signed"}}
+		return nil
+	}
+
+	t.Run("post", func(t *testing.T) {
+		keys = nil
+		httpReq, err := http.NewRequest(http.MethodPost, ts.URL, strings.NewReader("payload"))
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		req, err := FromRequest(httpReq)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		_, info, _ := client.DoWithInfo(req)
+		if info.NumAttempts() != 3 {
+			t.Fatalf("expected 3 attempts, got %d", info.NumAttempts())
+		}
+		for _, key := range keys {
+			if key != "key-1" {
+				t.Fatalf("expected every attempt to carry key-1, got %q", keys)
+			}
+		}
+		if httpReq.Header.Get("X-Request-Key") != "" {
+			t.Fatal("the caller's request should not be modified")
+		}
+		if !strings.Contains(buf.String(), "(idempotency key: key-1)") {
+			t.Fatalf("expected the key to be logged: %s", buf.String())
+		}
+	})
+
+	t.Run("post_with_key", func(t *testing.T) {
+		keys = nil
+		req, err := NewRequest(http.MethodPost, ts.URL, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		req.Header.Set("X-Request-Key", "mine")
+
+		_, _, _ = client.DoWithInfo(req)
+		if len(keys) != 3 || keys[0] != "mine" || keys[2] != "mine" {
+			t.Fatalf("expected the existing key to be kept, got %q", keys)
+		}
+	})
+
+	t.Run("get", func(t *testing.T) {
+		keys = nil
+		req, err := NewRequest(http.MethodGet, ts.URL, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+
+		_, _, _ = client.DoWithInfo(req)
+		if len(keys) != 3 || keys[0] != "" {
+			t.Fatalf("expected no key on idempotent requests, got %q", keys)
+		}
+	})
+}
@@ -0,0 +1,183 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// The functions in this file build CheckRetry policies out of small pieces.
+// Every piece and combinator returns a plain CheckRetry, so the result can be
+// assigned to Client.CheckRetry or combined further. Like DefaultRetryPolicy,
+// they never retry once the request context is done.
+
+// RetryOnStatus returns a CheckRetry which retries responses with any of the
+// given status codes.
+func RetryOnStatus(codes ...int) CheckRetry {
+	set := make(map[int]struct{}, len(codes))
+	for _, code := range codes {
+		set[code] = struct{}{}
+	}
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if resp == nil {
+			return false, nil
+		}
+		_, ok := set[resp.StatusCode]
+		return ok, nil
+	}
+}
+
+// RetryOnMethods returns a CheckRetry which matches any attempt of a request
+// made with one of the given methods. It doesn't look at the outcome of the
+// attempt, so it is meant to be combined with other policies using
+// AllPolicies.
+func RetryOnMethods(methods ...string) CheckRetry {
+	set := make(map[string]struct{}, len(methods))
+	for _, method := range methods {
+		set[strings.ToUpper(method)] = struct{}{}
+	}
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		method, ok := requestMethod(ctx, resp)
+		if !ok {
+			return false, nil
+		}
+		if method == "" {
+			method = http.MethodGet
+		}
+		_, ok = set[method]
+		return ok, nil
+	}
+}
+
+// RetryOnTimeouts returns a CheckRetry which retries attempts that failed
+// because they timed out, such as when the HTTP client's Timeout elapsed.
+func RetryOnTimeouts() CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if err == nil {
+			return false, nil
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return true, nil
+		}
+		return errors.Is(err, context.DeadlineExceeded), nil
+	}
+}
+
+// RetryIfHeader returns a CheckRetry which retries responses carrying the
+// named header with the given value. Values are compared case-insensitively.
+func RetryIfHeader(name, value string) CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if resp == nil {
+			return false, nil
+		}
+		for _, v := range resp.Header.Values(name) {
+			if strings.EqualFold(strings.TrimSpace(v), value) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// AnyPolicy returns a CheckRetry which retries if any of the given policies
+// wants to. If none does, the first error returned by a policy is returned.
+func AnyPolicy(policies ...CheckRetry) CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		var firstErr error
+		for _, policy := range policies {
+			shouldRetry, checkErr := policy(ctx, resp, err)
+			if shouldRetry {
+				return true, nil
+			}
+			if firstErr == nil {
+				firstErr = checkErr
+			}
+		}
+		return false, firstErr
+	}
+}
+
+// AllPolicies returns a CheckRetry which retries only if all of the given
+// policies want to. Evaluation stops at the first policy which doesn't, and
+// its error is returned.
+func AllPolicies(policies ...CheckRetry) CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if len(policies) == 0 {
+			return false, nil
+		}
+		for _, policy := range policies {
+			shouldRetry, checkErr := policy(ctx, resp, err)
+			if !shouldRetry {
+				return false, checkErr
+			}
+		}
+		return true, nil
+	}
+}
+
+// NotPolicy returns a CheckRetry which retries when the given policy doesn't,
+// and vice versa. Errors returned by the policy are discarded.
+func NotPolicy(policy CheckRetry) CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		shouldRetry, _ := policy(ctx, resp, err)
+		return !shouldRetry, nil
+	}
+}
+
+// FirstMatchPolicy returns a CheckRetry which defers to the first of the given
+// policies which either wants to retry or returns an error. This allows a
+// policy early in the list to stop retries that a later one would allow. If
+// no policy matches, the request is not retried.
+func FirstMatchPolicy(policies ...CheckRetry) CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		for _, policy := range policies {
+			shouldRetry, checkErr := policy(ctx, resp, err)
+			if shouldRetry || checkErr != nil {
+				return shouldRetry, checkErr
+			}
+		}
+		return false, nil
+	}
+}
+
+// requestMethod returns the method of the request made by the attempt
+// described by ctx and resp.
+func requestMethod(ctx context.Context, resp *http.Response) (string, bool) {
+	if req := attemptRequest(ctx, resp); req != nil {
+		return req.Method, true
+	}
+	return "", false
+}
+
+// attemptRequest returns the request made by the attempt described by ctx
+// and resp, or nil if it can't be determined.
+func attemptRequest(ctx context.Context, resp *http.Response) *http.Request {
+	if state := attemptStateFromContext(ctx); state != nil {
+		return state.req
+	}
+	if resp != nil {
+		return resp.Request
+	}
+	return nil
+}
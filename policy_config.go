@@ -0,0 +1,480 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// backoffsByName are the Backoff algorithms which can be selected by name in
+// a policy document.
+var backoffsByName = map[string]randBackoff{
+	"exponential":              ignoreRand(DefaultBackoff),
+	"linear_jitter":            linearJitterBackoff,
+	"rate_limit_linear_jitter": rateLimitLinearJitterBackoff,
+}
+
+// errorKindsByName are the kinds of errors which can be retried by name in a
+// policy document.
+var errorKindsByName = map[string]CheckRetry{
+	"timeout":            RetryOnTimeouts(),
+	"connection_reset":   retryOnErrors(syscall.ECONNRESET, syscall.ECONNABORTED, syscall.EPIPE),
+	"connection_refused": retryOnErrors(syscall.ECONNREFUSED),
+	"dns":                retryOnDNSErrors,
+}
+
+// PolicyError is returned by LoadPolicy when a policy document is invalid.
+type PolicyError struct {
+	// Path is the JSON path of the offending value, such as
+	// "$.overrides[1].retry_max".
+	Path string
+
+	// Err describes what is wrong with the value.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("invalid retry policy at %s: %s", e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// PolicyConfig is a retry policy loaded from a JSON document by LoadPolicy.
+type PolicyConfig struct {
+	root      resolvedPolicy
+	overrides []policyOverride
+}
+
+// policyRules holds the settings given at one level of a policy document.
+// Nil values are inherited from the level above.
+type policyRules struct {
+	retryMax     *int
+	retryWaitMin *time.Duration
+	retryWaitMax *time.Duration
+	backoff      randBackoff
+	statuses     []int
+	methods      []string
+	errorKinds   []string
+}
+
+// resolvedPolicy holds the effective settings for a request.
+type resolvedPolicy struct {
+	retryMax     int
+	retryWaitMin time.Duration
+	retryWaitMax time.Duration
+	backoff      randBackoff
+	checkRetry   CheckRetry
+}
+
+// policyOverride is a resolvedPolicy that applies to requests matching a
+// route, as for a PolicyRouter.
+type policyOverride struct {
+	route  PolicyRoute
+	policy resolvedPolicy
+}
+
+// LoadPolicy parses a JSON policy document. All fields are optional:
+//
+//	{
+//	  "retry_max": 4,
+//	  "retry_wait_min": "1s",
+//	  "retry_wait_max": "30s",
+//	  "backoff": "exponential",
+//	  "retry_on_status": [429, 502, 503, 504],
+//	  "retry_on_methods": ["GET", "PUT"],
+//	  "retry_on_errors": ["timeout", "connection_reset", "dns"],
+//	  "overrides": [
+//	    {"host": "api.example.com", "path_prefix": "/v1/", "retry_max": 2}
+//	  ]
+//	}
+//
+// Durations use the time.ParseDuration format. The backoff is one of
+// "exponential" (DefaultBackoff), "linear_jitter" (LinearJitterBackoff) or
+// "rate_limit_linear_jitter" (RateLimitLinearJitterBackoff). The error kinds
+// are "timeout", "connection_reset", "connection_refused" and "dns".
+//
+// A request is retried when its method is one of retry_on_methods, if given,
+// and its response status is one of retry_on_status or its error is one of
+// retry_on_errors. When neither retry_on_status nor retry_on_errors is given,
+// the outcome is judged by DefaultRetryPolicy instead.
+//
+// Each override applies to the requests whose host matches its host, a
+// pattern like the Host of a PolicyRoute which is matched with and without
+// the port, and whose path starts with its path_prefix. An override inherits
+// any setting it doesn't give from the top level. The first matching
+// override is used.
+//
+// If the document is invalid, the returned error is a *PolicyError giving the
+// path of the offending value.
+func LoadPolicy(r io.Reader) (*PolicyConfig, error) {
+	var doc map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, &PolicyError{Path: "$", Err: err}
+	}
+
+	var rawOverrides json.RawMessage
+	if raw, ok := doc["overrides"]; ok {
+		rawOverrides = raw
+		delete(doc, "overrides")
+	}
+
+	rules, err := parsePolicyRules("$", doc, nil)
+	if err != nil {
+		return nil, err
+	}
+	defaults := resolvedPolicy{
+		retryMax:     defaultRetryMax,
+		retryWaitMin: defaultRetryWaitMin,
+		retryWaitMax: defaultRetryWaitMax,
+		backoff:      ignoreRand(DefaultBackoff),
+	}
+	p := &PolicyConfig{}
+	p.root, err = rules.resolve("$", defaults, policyRules{})
+	if err != nil {
+		return nil, err
+	}
+
+	if rawOverrides == nil {
+		return p, nil
+	}
+	var overrides []map[string]json.RawMessage
+	if err := json.Unmarshal(rawOverrides, &overrides); err != nil {
+		return nil, &PolicyError{Path: "$.overrides", Err: errors.New("must be an array of objects")}
+	}
+	for i, fields := range overrides {
+		path := fmt.Sprintf("$.overrides[%d]", i)
+
+		var o policyOverride
+		match := map[string]*string{"host": &o.route.Host, "path_prefix": &o.route.pathPrefix}
+		overrideRules, err := parsePolicyRules(path, fields, match)
+		if err != nil {
+			return nil, err
+		}
+		if o.route.Host == "" && o.route.pathPrefix == "" {
+			return nil, &PolicyError{Path: path, Err: errors.New("must set host or path_prefix")}
+		}
+		// Only the host is a pattern, so it is the only thing which can be
+		// malformed.
+		if o.route, err = compileRoute(o.route); err != nil {
+			return nil, &PolicyError{Path: path + ".host", Err: err}
+		}
+		o.policy, err = overrideRules.resolve(path, defaults, rules)
+		if err != nil {
+			return nil, err
+		}
+		p.overrides = append(p.overrides, o)
+	}
+	return p, nil
+}
+
+// parsePolicyRules parses the settings of one level of a policy document
+// found at path. The string fields in match are also accepted, and are
+// required to be non-empty if present.
+func parsePolicyRules(path string, fields map[string]json.RawMessage, match map[string]*string) (policyRules, error) {
+	var rules policyRules
+
+	// Visit the fields in a stable order so that errors are reproducible.
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		raw := fields[key]
+		fieldPath := path + "." + key
+		invalid := func(format string, args ...interface{}) error {
+			return &PolicyError{Path: fieldPath, Err: fmt.Errorf(format, args...)}
+		}
+
+		if target, ok := match[key]; ok {
+			if err := json.Unmarshal(raw, target); err != nil || *target == "" {
+				return rules, invalid("must be a non-empty string")
+			}
+			continue
+		}
+
+		switch key {
+		case "retry_max":
+			var n int
+			if err := json.Unmarshal(raw, &n); err != nil || n < 0 {
+				return rules, invalid("must be a non-negative integer")
+			}
+			rules.retryMax = &n
+
+		case "retry_wait_min", "retry_wait_max":
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return rules, invalid("must be a duration string such as \"1.5s\"")
+			}
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return rules, invalid("%v", err)
+			}
+			if d < 0 {
+				return rules, invalid("must not be negative")
+			}
+			if key == "retry_wait_min" {
+				rules.retryWaitMin = &d
+			} else {
+				rules.retryWaitMax = &d
+			}
+
+		case "backoff":
+			var name string
+			if err := json.Unmarshal(raw, &name); err != nil {
+				return rules, invalid("must be a string")
+			}
+			backoff, ok := backoffsByName[name]
+			if !ok {
+				return rules, invalid("unknown backoff %q", name)
+			}
+			rules.backoff = backoff
+
+		case "retry_on_status":
+			var items []json.RawMessage
+			if err := json.Unmarshal(raw, &items); err != nil {
+				return rules, invalid("must be an array of status codes")
+			}
+			rules.statuses = []int{}
+			for i, item := range items {
+				var code int
+				if err := json.Unmarshal(item, &code); err != nil || code < 100 || code > 599 {
+					return rules, &PolicyError{
+						Path: fmt.Sprintf("%s[%d]", fieldPath, i),
+						Err:  errors.New("must be an HTTP status code between 100 and 599"),
+					}
+				}
+				rules.statuses = append(rules.statuses, code)
+			}
+
+		case "retry_on_methods":
+			var items []json.RawMessage
+			if err := json.Unmarshal(raw, &items); err != nil {
+				return rules, invalid("must be an array of HTTP methods")
+			}
+			rules.methods = []string{}
+			for i, item := range items {
+				var method string
+				if err := json.Unmarshal(item, &method); err != nil || method == "" || strings.ContainsAny(method, " \t") {
+					return rules, &PolicyError{
+						Path: fmt.Sprintf("%s[%d]", fieldPath, i),
+						Err:  errors.New("must be an HTTP method"),
+					}
+				}
+				rules.methods = append(rules.methods, method)
+			}
+
+		case "retry_on_errors":
+			var items []json.RawMessage
+			if err := json.Unmarshal(raw, &items); err != nil {
+				return rules, invalid("must be an array of error kinds")
+			}
+			rules.errorKinds = []string{}
+			for i, item := range items {
+				var kind string
+				err := json.Unmarshal(item, &kind)
+				if _, ok := errorKindsByName[kind]; err != nil || !ok {
+					return rules, &PolicyError{
+						Path: fmt.Sprintf("%s[%d]", fieldPath, i),
+						Err:  fmt.Errorf("unknown error kind %s", item),
+					}
+				}
+				rules.errorKinds = append(rules.errorKinds, kind)
+			}
+
+		default:
+			return rules, invalid("unknown field")
+		}
+	}
+	return rules, nil
+}
+
+// resolve computes the effective policy described by r at path, inheriting
+// unset values from parent and then from defaults.
+func (r policyRules) resolve(path string, defaults resolvedPolicy, parent policyRules) (resolvedPolicy, error) {
+	p := defaults
+	for _, rules := range []policyRules{parent, r} {
+		if rules.retryMax != nil {
+			p.retryMax = *rules.retryMax
+		}
+		if rules.retryWaitMin != nil {
+			p.retryWaitMin = *rules.retryWaitMin
+		}
+		if rules.retryWaitMax != nil {
+			p.retryWaitMax = *rules.retryWaitMax
+		}
+		if rules.backoff != nil {
+			p.backoff = rules.backoff
+		}
+	}
+	if p.retryWaitMin > p.retryWaitMax {
+		return p, &PolicyError{
+			Path: path + ".retry_wait_min",
+			Err:  fmt.Errorf("%s is greater than retry_wait_max of %s", p.retryWaitMin, p.retryWaitMax),
+		}
+	}
+
+	statuses, methods, errorKinds := parent.statuses, parent.methods, parent.errorKinds
+	if r.statuses != nil {
+		statuses = r.statuses
+	}
+	if r.methods != nil {
+		methods = r.methods
+	}
+	if r.errorKinds != nil {
+		errorKinds = r.errorKinds
+	}
+
+	p.checkRetry = DefaultRetryPolicy
+	if statuses != nil || errorKinds != nil {
+		outcomes := []CheckRetry{RetryOnStatus(statuses...)}
+		for _, kind := range errorKinds {
+			outcomes = append(outcomes, errorKindsByName[kind])
+		}
+		p.checkRetry = AnyPolicy(outcomes...)
+	}
+	if methods != nil {
+		p.checkRetry = AllPolicies(RetryOnMethods(methods...), p.checkRetry)
+	}
+	return p, nil
+}
+
+// match returns the effective policy for req.
+func (p *PolicyConfig) match(req *http.Request) *resolvedPolicy {
+	if req == nil || req.URL == nil {
+		return &p.root
+	}
+	for i := range p.overrides {
+		if p.overrides[i].route.matches(req) {
+			return &p.overrides[i].policy
+		}
+	}
+	return &p.root
+}
+
+// matchAttempt returns the effective policy for the attempt described by ctx
+// and resp.
+func (p *PolicyConfig) matchAttempt(ctx context.Context, resp *http.Response) *resolvedPolicy {
+	if req := attemptRequest(ctx, resp); req != nil {
+		return p.match(req)
+	}
+	return &p.root
+}
+
+// CheckRetry returns a CheckRetry implementing the policy. The retry_max of
+// an override is enforced for attempts made by Client.Do, which then fails
+// with ErrRetriesExhausted, but the client's RetryMax still bounds the total
+// number of retries; see Apply.
+func (p *PolicyConfig) CheckRetry() CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		rp := p.matchAttempt(ctx, resp)
+		shouldRetry, checkErr := rp.checkRetry(ctx, resp, err)
+		if state := attemptStateFromContext(ctx); shouldRetry && state != nil && state.attempt.Number > rp.retryMax {
+			return false, ErrRetriesExhausted
+		}
+		return shouldRetry, checkErr
+	}
+}
+
+// Backoff returns a Backoff implementing the policy. When the attempt failed
+// without a response, the request's host and path aren't known, so the
+// top-level settings are used; see Apply for a way around this.
+func (p *PolicyConfig) Backoff() Backoff {
+	return func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		rp, rnd := &p.root, defaultRand
+		if resp != nil && resp.Request != nil {
+			rp = p.match(resp.Request)
+			rnd = randFromContext(resp.Request.Context())
+		}
+		return rp.backoff(rnd, rp.retryWaitMin, rp.retryWaitMax, attemptNum, resp)
+	}
+}
+
+// CheckRetryV2 returns a CheckRetryV2 implementing the policy, including the
+// backoff of the matching override.
+func (p *PolicyConfig) CheckRetryV2() CheckRetryV2 {
+	return func(ctx context.Context, req *http.Request, resp *http.Response, err error, attempt AttemptInfo) RetryDecision {
+		rp := p.match(req)
+		shouldRetry, checkErr := rp.checkRetry(ctx, resp, err)
+		if !shouldRetry {
+			return RetryDecision{Err: checkErr}
+		}
+		if attempt.Number > rp.retryMax {
+			return RetryDecision{
+				Err:    ErrRetriesExhausted,
+				Reason: fmt.Sprintf("retry_max of %d reached", rp.retryMax),
+			}
+		}
+		return RetryDecision{
+			Retry: true,
+			Err:   checkErr,
+			Wait:  rp.backoff(randFromContext(ctx), rp.retryWaitMin, rp.retryWaitMax, attempt.Number-1, resp),
+		}
+	}
+}
+
+// Apply configures c to follow the policy. The client's RetryMax is set to
+// the largest retry_max in the document, and each request is held to the
+// retry_max of the override matching it.
+func (p *PolicyConfig) Apply(c *Client) {
+	c.RetryMax = p.root.retryMax
+	for _, o := range p.overrides {
+		if o.policy.retryMax > c.RetryMax {
+			c.RetryMax = o.policy.retryMax
+		}
+	}
+	c.RetryWaitMin = p.root.retryWaitMin
+	c.RetryWaitMax = p.root.retryWaitMax
+	c.CheckRetry = p.CheckRetry()
+	c.CheckRetryV2 = p.CheckRetryV2()
+	c.Backoff = p.Backoff()
+}
+
+// NewClient returns a new Client with default settings which follows the
+// policy.
+func (p *PolicyConfig) NewClient() *Client {
+	c := NewClient()
+	p.Apply(c)
+	return c
+}
+
+// retryOnErrors returns a CheckRetry which retries errors matching any of
+// targets.
+func retryOnErrors(targets ...error) CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		for _, target := range targets {
+			if errors.Is(err, target) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// retryOnDNSErrors is a CheckRetry which retries failed DNS lookups.
+func retryOnDNSErrors(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr), nil
+}
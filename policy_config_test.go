@@ -0,0 +1,175 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPolicy_invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		path string
+	}{
+		{"not_json", `{`, "$"},
+		{"unknown_field", `{"retry_maximum": 3}`, "$.retry_maximum"},
+		{"negative_retry_max", `{"retry_max": -1}`, "$.retry_max"},
+		{"bad_duration", `{"retry_wait_min": "soon"}`, "$.retry_wait_min"},
+		{"numeric_duration", `{"retry_wait_max": 30}`, "$.retry_wait_max"},
+		{"min_above_max", `{"retry_wait_min": "10s", "retry_wait_max": "1s"}`, "$.retry_wait_min"},
+		{"unknown_backoff", `{"backoff": "quadratic"}`, "$.backoff"},
+		{"bad_status", `{"retry_on_status": [503, 999]}`, "$.retry_on_status[1]"},
+		{"bad_method", `{"retry_on_methods": ["GET", ""]}`, "$.retry_on_methods[1]"},
+		{"unknown_error_kind", `{"retry_on_errors": ["timeout", "cosmic_rays"]}`, "$.retry_on_errors[1]"},
+		{"overrides_not_array", `{"overrides": {}}`, "$.overrides"},
+		{"override_without_match", `{"overrides": [{"host": "a"}, {"retry_max": 1}]}`, "$.overrides[1]"},
+		{"override_empty_host", `{"overrides": [{"host": ""}]}`, "$.overrides[0].host"},
+		{"override_bad_field", `{"overrides": [{"host": "a", "retry_on_status": ["503"]}]}`, "$.overrides[0].retry_on_status[0]"},
+		{"override_nested", `{"overrides": [{"host": "a", "overrides": []}]}`, "$.overrides[0].overrides"},
+		{"override_bad_pattern", `{"overrides": [{"host": "[a"}]}`, "$.overrides[0].host"},
+		{"override_inherited_wait", `{"retry_wait_max": "2s", "overrides": [{"host": "a", "retry_wait_min": "5s"}]}`, "$.overrides[0].retry_wait_min"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadPolicy(strings.NewReader(tt.doc))
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("expected a *PolicyError, got %v", err)
+			}
+			if policyErr.Path != tt.path {
+				t.Fatalf("expected path %s, got %s (%v)", tt.path, policyErr.Path, err)
+			}
+		})
+	}
+}
+
+func TestPolicyConfig_match(t *testing.T) {
+	p, err := LoadPolicy(strings.NewReader(`{"retry_max": 1, "overrides": [
+		{"host": "*.Example.com", "path_prefix": "/v1/", "retry_max": 2},
+		{"host": "example.com", "retry_max": 3},
+		{"path_prefix": "/api", "retry_max": 4}
+	]}`))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Hosts are matched like those of the routes of a PolicyRouter, and
+	// paths by prefix.
+	tests := []struct {
+		url      string
+		retryMax int
+	}{
+		{"http://api.example.com/v1/users/items/42", 2},
+		{"http://API.example.com:8080/v1/", 2},
+		{"http://api.example.com/v2/users", 1},
+		{"http://example.com/v1/users/items/42", 3},
+		{"http://example.com:8080/", 3},
+		{"http://example.org/", 1},
+		{"http://example.org/api/users", 4},
+		{"http://example.org/apiv2", 4},
+		{"http://example.org/v1/api", 1},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", tt.url, nil)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if got := p.match(req).retryMax; got != tt.retryMax {
+			t.Fatalf("expected retry_max %d for %s, got %d", tt.retryMax, tt.url, got)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/conflict") {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	p, err := LoadPolicy(strings.NewReader(`{
+		"retry_max": 2,
+		"retry_wait_min": "1ms",
+		"retry_wait_max": "2ms",
+		"backoff": "linear_jitter",
+		"retry_on_status": [503],
+		"retry_on_methods": ["GET"],
+		"retry_on_errors": ["connection_refused"],
+		"overrides": [
+			{"host": "127.0.0.1", "path_prefix": "/v1/", "retry_max": 4, "retry_on_status": [409, 503]},
+			{"path_prefix": "/once/", "retry_max": 0}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	client := p.NewClient()
+	if client.RetryMax != 4 {
+		t.Fatalf("expected RetryMax to be the largest retry_max, got %d", client.RetryMax)
+	}
+	if client.RetryWaitMin != time.Millisecond || client.RetryWaitMax != 2*time.Millisecond {
+		t.Fatalf("unexpected waits %s and %s", client.RetryWaitMin, client.RetryWaitMax)
+	}
+
+	tests := []struct {
+		method   string
+		url      string
+		attempts int
+		kind     error
+	}{
+		{"GET", ts.URL + "/root", 3, ErrRetriesExhausted},
+		{"POST", ts.URL + "/root", 1, nil},
+		{"GET", ts.URL + "/v1/items", 5, ErrRetriesExhausted},
+		{"GET", ts.URL + "/v1/conflict", 5, ErrRetriesExhausted},
+		{"GET", ts.URL + "/conflict", 1, nil},
+		{"GET", ts.URL + "/once/", 1, ErrRetriesExhausted},
+		// Nothing is listening on this port.
+		{"GET", "http://127.0.0.1:1/v1/items", 5, ErrRetriesExhausted},
+		{"GET", "http://127.0.0.1:1/root", 3, ErrRetriesExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.url, func(t *testing.T) {
+			req, err := NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			_, info, err := client.DoWithInfo(req)
+			if info.NumAttempts() != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, info.NumAttempts())
+			}
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("expected %v, got %v", tt.kind, err)
+			}
+			for _, a := range info.Attempts[:len(info.Attempts)-1] {
+				if a.Wait < time.Millisecond || a.Wait > 2*time.Millisecond*time.Duration(a.Number) {
+					t.Fatalf("unexpected linear jitter wait %s for attempt %d", a.Wait, a.Number)
+				}
+			}
+		})
+	}
+
+	// The CheckRetry and Backoff pair applies the same rules.
+	client = NewClient()
+	client.RetryMax = 4
+	client.CheckRetry = p.CheckRetry()
+	client.Backoff = p.Backoff()
+	_, info, err := client.DoWithInfo(mustNewRequest(t, "GET", ts.URL+"/root"))
+	if info.NumAttempts() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", info.NumAttempts())
+	}
+	if !errors.Is(err, ErrRetriesExhausted) {
+		t.Fatalf("expected ErrRetriesExhausted, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newPolicyTestServer(t *testing.T) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/status/"):
+			code, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/status/"))
+			if err != nil {
+				t.Errorf("bad status: %v", err)
+			}
+			w.WriteHeader(code)
+		case r.URL.Path == "/header":
+			w.Header().Set("X-Should-Retry", r.URL.Query().Get("value"))
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// policyAttempts returns how many attempts the client makes for the request
+// when retrying according to policy.
+func policyAttempts(t *testing.T, policy CheckRetry, method, url string) int {
+	t.Helper()
+
+	client := NewClient()
+	client.HTTPClient.Timeout = 50 * time.Millisecond
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.RetryMax = 2
+	client.CheckRetry = policy
+	client.ErrorHandler = PassthroughErrorHandler
+
+	req, err := NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	resp, info, _ := client.DoWithInfo(req)
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return info.NumAttempts()
+}
+
+func TestPolicy_pieces(t *testing.T) {
+	ts := newPolicyTestServer(t)
+
+	tests := []struct {
+		name     string
+		policy   CheckRetry
+		method   string
+		path     string
+		attempts int
+	}{
+		{"status_match", RetryOnStatus(408, 425, 429, 502, 503, 504), "GET", "/status/503", 3},
+		{"status_429", RetryOnStatus(408, 425, 429, 502, 503, 504), "GET", "/status/429", 3},
+		{"status_no_match", RetryOnStatus(408, 425, 429, 502, 503, 504), "GET", "/status/500", 1},
+		{"status_ok", RetryOnStatus(408, 425, 429, 502, 503, 504), "GET", "/status/200", 1},
+		{"status_error", RetryOnStatus(503), "GET", "/slow", 1},
+		{"methods_match", RetryOnMethods("get", "HEAD"), "GET", "/status/200", 3},
+		{"methods_no_match", RetryOnMethods("GET", "HEAD"), "POST", "/status/200", 1},
+		{"methods_transport_error", RetryOnMethods("GET"), "GET", "/slow", 3},
+		{"timeouts_match", RetryOnTimeouts(), "GET", "/slow", 3},
+		{"timeouts_no_match", RetryOnTimeouts(), "GET", "/status/503", 1},
+		{"header_match", RetryIfHeader("X-Should-Retry", "true"), "GET", "/header?value=TRUE", 3},
+		{"header_no_match", RetryIfHeader("X-Should-Retry", "true"), "GET", "/header?value=false", 1},
+		{"header_missing", RetryIfHeader("X-Should-Retry", "true"), "GET", "/status/503", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policyAttempts(t, tt.policy, tt.method, ts.URL+tt.path); got != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, got)
+			}
+		})
+	}
+}
+
+func TestPolicy_combinators(t *testing.T) {
+	ts := newPolicyTestServer(t)
+
+	errStop := errors.New("stop")
+	stop := func(context.Context, *http.Response, error) (bool, error) {
+		return false, errStop
+	}
+	retry503 := RetryOnStatus(503)
+	onlyGets := RetryOnMethods("GET")
+
+	tests := []struct {
+		name     string
+		policy   CheckRetry
+		method   string
+		path     string
+		attempts int
+	}{
+		{"any_first", AnyPolicy(retry503, RetryOnTimeouts()), "GET", "/status/503", 3},
+		{"any_second", AnyPolicy(retry503, RetryOnTimeouts()), "GET", "/slow", 3},
+		{"any_none", AnyPolicy(retry503, RetryOnTimeouts()), "GET", "/status/500", 1},
+		{"any_empty", AnyPolicy(), "GET", "/status/503", 1},
+		{"all_match", AllPolicies(onlyGets, retry503), "GET", "/status/503", 3},
+		{"all_method_mismatch", AllPolicies(onlyGets, retry503), "POST", "/status/503", 1},
+		{"all_status_mismatch", AllPolicies(onlyGets, retry503), "GET", "/status/500", 1},
+		{"all_empty", AllPolicies(), "GET", "/status/503", 1},
+		{"not_match", AllPolicies(NotPolicy(RetryOnStatus(200, 404)), RetryOnStatus(500, 503)), "GET", "/status/500", 3},
+		{"not_no_match", NotPolicy(RetryOnStatus(200)), "GET", "/status/200", 1},
+		{"first_match_stop", FirstMatchPolicy(AllPolicies(NotPolicy(onlyGets), stop), retry503), "POST", "/status/503", 1},
+		{"first_match_fallthrough", FirstMatchPolicy(AllPolicies(NotPolicy(onlyGets), stop), retry503), "GET", "/status/503", 3},
+		{"first_match_none", FirstMatchPolicy(retry503), "GET", "/status/500", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policyAttempts(t, tt.policy, tt.method, ts.URL+tt.path); got != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, got)
+			}
+		})
+	}
+}
+
+func TestPolicy_errors(t *testing.T) {
+	errA, errB := errors.New("a"), errors.New("b")
+	fail := func(err error) CheckRetry {
+		return func(context.Context, *http.Response, error) (bool, error) {
+			return false, err
+		}
+	}
+	ctx := context.Background()
+
+	if _, err := AnyPolicy(fail(errA), fail(errB))(ctx, nil, nil); err != errA {
+		t.Fatalf("expected Any to return the first error, got %v", err)
+	}
+	if _, err := AllPolicies(RetryOnTimeouts(), fail(errB))(ctx, nil, nil); err != nil {
+		t.Fatalf("expected All to stop at the first policy, got %v", err)
+	}
+	if _, err := FirstMatchPolicy(fail(nil), fail(errB), fail(errA))(ctx, nil, nil); err != errB {
+		t.Fatalf("expected FirstMatch to return the first error, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	for name, policy := range map[string]CheckRetry{
+		"status":  RetryOnStatus(503),
+		"methods": RetryOnMethods("GET"),
+		"timeout": RetryOnTimeouts(),
+		"header":  RetryIfHeader("X-Should-Retry", "true"),
+		"not":     NotPolicy(RetryOnStatus(200)),
+	} {
+		shouldRetry, err := policy(canceled, &http.Response{StatusCode: 503}, context.Canceled)
+		if shouldRetry || err != context.Canceled {
+			t.Fatalf("%s: expected no retry once the context is done, got %t, %v", name, shouldRetry, err)
+		}
+	}
+}
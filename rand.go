@@ -0,0 +1,126 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Rand is a source of random numbers for jittered backoffs. It must be safe
+// for concurrent use. A *rand.Rand has the right methods but isn't safe for
+// concurrent use; wrap it with NewRand instead.
+type Rand interface {
+	// Int63n returns a non-negative pseudo-random number in [0,n). It
+	// panics if n <= 0.
+	Int63n(n int64) int64
+
+	// Float64 returns a pseudo-random number in [0.0,1.0).
+	Float64() float64
+}
+
+// NewRand returns a Rand which is safe for concurrent use and produces the
+// same sequence of numbers for the same seed, so that tests and simulations
+// can reproduce jittered backoff schedules exactly.
+func NewRand(seed int64) Rand {
+	return &lockedRand{r: rand.New(rand.NewSource(seed))}
+}
+
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (r *lockedRand) Int63n(n int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Int63n(n)
+}
+
+func (r *lockedRand) Float64() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.r.Float64()
+}
+
+// globalRand is the default Rand, drawing from the top-level functions of
+// math/rand, which are randomly seeded and safe for concurrent use.
+type globalRand struct{}
+
+func (globalRand) Int63n(n int64) int64 { return rand.Int63n(n) }
+func (globalRand) Float64() float64     { return rand.Float64() }
+
+// defaultRand is used when no Rand is configured.
+var defaultRand Rand = globalRand{}
+
+// randSetter is implemented by the BackoffStrategies of the package, so that
+// Client.Do can make them draw from Client.Rand.
+type randSetter interface {
+	setRand(Rand)
+}
+
+// rnd returns the Rand of the client.
+func (c *Client) rnd() Rand {
+	if c.Rand != nil {
+		return c.Rand
+	}
+	return defaultRand
+}
+
+// randFromContext returns the Rand of the client making the attempt
+// described by ctx.
+func randFromContext(ctx context.Context) Rand {
+	if state := attemptStateFromContext(ctx); state != nil && state.rnd != nil {
+		return state.rnd
+	}
+	return defaultRand
+}
+
+// randFromResponse returns the Rand of the client which made the attempt
+// getting resp. Without a response, the attempt isn't known.
+func randFromResponse(resp *http.Response) Rand {
+	if resp == nil || resp.Request == nil {
+		return defaultRand
+	}
+	return randFromContext(resp.Request.Context())
+}
+
+// randBackoff is a Backoff drawing from the given Rand, so that it can be
+// used where the attempt, and thus the Rand of the client, is known even
+// without a response.
+type randBackoff func(rnd Rand, min, max time.Duration, attemptNum int, resp *http.Response) time.Duration
+
+// ignoreRand returns a randBackoff calling backoff, which doesn't draw
+// random numbers or draws them from the response.
+func ignoreRand(backoff Backoff) randBackoff {
+	return func(_ Rand, min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+		return backoff(min, max, attemptNum, resp)
+	}
+}
+
+// jitteredBackoffs are the randBackoffs implementing the jittered Backoffs
+// of the package, by code pointer. Without a response, those can't find the
+// Rand of the client, so it is given to them explicitly instead.
+var jitteredBackoffs = map[uintptr]randBackoff{
+	reflect.ValueOf(LinearJitterBackoff).Pointer():          linearJitterBackoff,
+	reflect.ValueOf(RateLimitLinearJitterBackoff).Pointer(): rateLimitLinearJitterBackoff,
+}
+
+// resolveBackoff returns the randBackoff implementing backoff. Top-level
+// functions have a code pointer of their own, so only the jittered Backoffs
+// of the package themselves are recognized; anything else, including a
+// wrapper of them, draws from the response it is given.
+func resolveBackoff(backoff Backoff) randBackoff {
+	if backoff == nil {
+		return nil
+	}
+	if fn, ok := jitteredBackoffs[reflect.ValueOf(backoff).Pointer()]; ok {
+		return fn
+	}
+	return ignoreRand(backoff)
+}
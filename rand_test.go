@@ -0,0 +1,118 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewRand(t *testing.T) {
+	a, b := NewRand(42), NewRand(42)
+	for i := 0; i < 100; i++ {
+		if x, y := a.Int63n(1000), b.Int63n(1000); x != y {
+			t.Fatalf("expected the same sequence for the same seed, got %d and %d", x, y)
+		}
+		if x, y := a.Float64(), b.Float64(); x != y {
+			t.Fatalf("expected the same sequence for the same seed, got %f and %f", x, y)
+		}
+	}
+
+	// It is safe for concurrent use.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				a.Int63n(10)
+				a.Float64()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClient_Rand(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	policy, err := LoadPolicy(strings.NewReader(`{"retry_max": 4, "retry_wait_min": "1ns", "retry_wait_max": "1ms", "backoff": "linear_jitter"}`))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		url       string
+		configure func(*Client)
+	}{
+		{"linear_jitter", ts.URL, func(c *Client) {
+			c.Backoff = LinearJitterBackoff
+		}},
+		{"linear_jitter_transport_error", "http://127.0.0.1:1/", func(c *Client) {
+			c.Backoff = LinearJitterBackoff
+		}},
+		{"rate_limit_linear_jitter_transport_error", "http://127.0.0.1:1/", func(c *Client) {
+			c.Backoff = RateLimitLinearJitterBackoff
+		}},
+		{"backoff_func_transport_error", "http://127.0.0.1:1/", func(c *Client) {
+			c.NewBackoffStrategy = func() BackoffStrategy { return BackoffFunc(LinearJitterBackoff, 1, time.Millisecond) }
+		}},
+		{"linear_jitter_wrapped", ts.URL, func(c *Client) {
+			c.Backoff = func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+				return LinearJitterBackoff(min, max, attemptNum, resp)
+			}
+		}},
+		{"rate_limit_linear_jitter", ts.URL, func(c *Client) {
+			c.Backoff = RateLimitLinearJitterBackoff
+		}},
+		{"strategy", ts.URL, func(c *Client) {
+			c.NewBackoffStrategy = func() BackoffStrategy { return NewFullJitter(time.Microsecond, time.Millisecond) }
+		}},
+		{"backoff_func", ts.URL, func(c *Client) {
+			c.NewBackoffStrategy = func() BackoffStrategy { return BackoffFunc(LinearJitterBackoff, 1, time.Millisecond) }
+		}},
+		{"policy_config", ts.URL, policy.Apply},
+		{"policy_config_transport_error", "http://127.0.0.1:1/", policy.Apply},
+	}
+
+	schedule := func(seed int64, url string, configure func(*Client)) []time.Duration {
+		client := NewClient()
+		client.RetryMax = 4
+		client.RetryWaitMin = 1
+		client.RetryWaitMax = time.Millisecond
+		client.Rand = NewRand(seed)
+		configure(client)
+
+		_, info, _ := client.DoWithInfo(mustNewRequest(t, "GET", url))
+		var waits []time.Duration
+		for _, a := range info.Attempts {
+			waits = append(waits, a.Wait)
+		}
+		return waits
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := schedule(1, tt.url, tt.configure)
+			if len(first) != 5 {
+				t.Fatalf("expected 5 attempts, got %d", len(first))
+			}
+			if again := schedule(1, tt.url, tt.configure); !reflect.DeepEqual(first, again) {
+				t.Fatalf("expected the same schedule for the same seed, got %v and %v", first, again)
+			}
+			if other := schedule(2, tt.url, tt.configure); reflect.DeepEqual(first, other) {
+				t.Fatalf("expected different schedules for different seeds, got %v twice", first)
+			}
+		})
+	}
+}
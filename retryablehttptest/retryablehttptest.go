@@ -0,0 +1,296 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+// Package retryablehttptest provides utilities for testing code which uses
+// retryablehttp: a test server playing a script of responses, a recorder of
+// the attempts it receives, a Client wired to a fake clock, and assertions
+// on the outcome.
+//
+// A typical test looks like this:
+//
+//	srv := retryablehttptest.NewServer(t,
+//		retryablehttptest.Status(503).WithHeader("Retry-After", "2"),
+//		retryablehttptest.ResetConnection(),
+//		retryablehttptest.Status(200).WithBody("ok"),
+//	)
+//	client, clock := retryablehttptest.NewClient(t)
+//
+//	resp, err := client.Get(srv.URL)
+//	...
+//	srv.AssertAttempts(t, 3)
+//	retryablehttptest.AssertBackoffSchedule(t, clock, 2*time.Second, 2*time.Second)
+package retryablehttptest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+)
+
+// Step is one scripted reaction of a Server to an attempt.
+type Step struct {
+	status int
+	header http.Header
+	body   string
+	reset  bool
+	hang   time.Duration
+}
+
+// Status returns a Step which responds with the given status code.
+func Status(code int) Step {
+	return Step{status: code}
+}
+
+// WithHeader returns a copy of s which also sets the given response header.
+func (s Step) WithHeader(key, value string) Step {
+	header := s.header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Add(key, value)
+	s.header = header
+	return s
+}
+
+// WithBody returns a copy of s which also writes the given response body.
+func (s Step) WithBody(body string) Step {
+	s.body = body
+	return s
+}
+
+// ResetConnection returns a Step which abruptly closes the connection
+// without responding, so that the client sees a connection reset.
+func ResetConnection() Step {
+	return Step{reset: true}
+}
+
+// Hang returns a Step which doesn't respond until d has elapsed or the client
+// gives up on the attempt, whichever comes first, and then responds with a
+// 200 OK. It can be combined with WithHeader and WithBody.
+func Hang(d time.Duration) Step {
+	return Step{status: http.StatusOK, hang: d}
+}
+
+// String describes the step.
+func (s Step) String() string {
+	switch {
+	case s.reset:
+		return "connection reset"
+	case s.hang > 0:
+		return fmt.Sprintf("hang %s", s.hang)
+	default:
+		return fmt.Sprintf("%d %s", s.status, http.StatusText(s.status))
+	}
+}
+
+// ServeHTTP plays the step.
+func (s Step) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.reset {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			panic("retryablehttptest: the connection can't be hijacked to reset it")
+		}
+		conn, _, err := hj.Hijack()
+		if err != nil {
+			panic(fmt.Sprintf("retryablehttptest: hijacking the connection: %v", err))
+		}
+		// Discarding unsent data on close makes the kernel send a RST.
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetLinger(0)
+		}
+		conn.Close()
+		return
+	}
+
+	if s.hang > 0 {
+		timer := time.NewTimer(s.hang)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+
+	for key, values := range s.header {
+		for _, v := range values {
+			w.Header().Add(key, v)
+		}
+	}
+	w.WriteHeader(s.status)
+	io.WriteString(w, s.body)
+}
+
+// Attempt is a request received by a Recorder.
+type Attempt struct {
+	// Method, Path and Header are those of the request. Path includes the
+	// query string, if any.
+	Method string
+	Path   string
+	Header http.Header
+
+	// Body is the complete request body.
+	Body []byte
+
+	// Time is when the request was received, according to the system clock.
+	Time time.Time
+}
+
+// Recorder is an http.Handler which records the requests it receives before
+// passing them to another handler.
+type Recorder struct {
+	handler http.Handler
+
+	mu       sync.Mutex
+	attempts []Attempt
+}
+
+// NewRecorder returns a Recorder passing requests to h once recorded.
+func NewRecorder(h http.Handler) *Recorder {
+	return &Recorder{handler: h}
+}
+
+// ServeHTTP records the request and passes it on.
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	rec.mu.Lock()
+	rec.attempts = append(rec.attempts, Attempt{
+		Method: r.Method,
+		Path:   r.URL.RequestURI(),
+		Header: r.Header.Clone(),
+		Body:   body,
+		Time:   time.Now(),
+	})
+	rec.mu.Unlock()
+
+	rec.handler.ServeHTTP(w, r)
+}
+
+// Attempts returns the requests recorded so far, in order.
+func (rec *Recorder) Attempts() []Attempt {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]Attempt(nil), rec.attempts...)
+}
+
+// AssertAttempts fails the test unless exactly want requests were recorded.
+func (rec *Recorder) AssertAttempts(t testing.TB, want int) {
+	t.Helper()
+	if got := len(rec.Attempts()); got != want {
+		t.Fatalf("expected %d attempts, got %d", want, got)
+	}
+}
+
+// AssertBodies fails the test unless every recorded request carried the
+// given body, as is expected of retries.
+func (rec *Recorder) AssertBodies(t testing.TB, want string) {
+	t.Helper()
+	for i, a := range rec.Attempts() {
+		if string(a.Body) != want {
+			t.Fatalf("attempt %d: expected body %q, got %q", i+1, want, a.Body)
+		}
+	}
+}
+
+// AssertHeader fails the test unless every recorded request carried the
+// given header value.
+func (rec *Recorder) AssertHeader(t testing.TB, key, want string) {
+	t.Helper()
+	for i, a := range rec.Attempts() {
+		if got := a.Header.Get(key); got != want {
+			t.Fatalf("attempt %d: expected header %s to be %q, got %q", i+1, key, want, got)
+		}
+	}
+}
+
+// Server is a test server which plays a script of Steps, one per request,
+// and records the requests it receives. Once the script is exhausted, the
+// last step is repeated.
+type Server struct {
+	*httptest.Server
+	*Recorder
+
+	mu    sync.Mutex
+	steps []Step
+	next  int
+}
+
+// NewServer starts a Server playing the given steps. It is closed when the
+// test ends. Without steps, every request is answered with a 200 OK.
+func NewServer(t testing.TB, steps ...Step) *Server {
+	t.Helper()
+	if len(steps) == 0 {
+		steps = []Step{Status(http.StatusOK)}
+	}
+	s := &Server{steps: steps}
+	s.Recorder = NewRecorder(http.HandlerFunc(s.play))
+	s.Server = httptest.NewServer(s.Recorder)
+	t.Cleanup(s.Server.Close)
+	return s
+}
+
+// play answers the request with the next step of the script.
+func (s *Server) play(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	step := s.steps[s.next]
+	if s.next < len(s.steps)-1 {
+		s.next++
+	}
+	s.mu.Unlock()
+
+	step.ServeHTTP(w, r)
+}
+
+// NewClient returns a retryablehttp.Client suited to tests, together with
+// the FakeClock it uses. The clock is in auto-advance mode, so waits between
+// attempts take no time, and the jitter of backoffs is drawn from a source
+// with a fixed seed, so the schedule is the same on every run. The client
+// logs through t.
+func NewClient(t testing.TB) (*retryablehttp.Client, *retryablehttp.FakeClock) {
+	clock := retryablehttp.NewFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.SetAutoAdvance(true)
+
+	client := retryablehttp.NewClient()
+	client.Logger = testLogger{t}
+	client.Clock = clock
+	client.Rand = retryablehttp.NewRand(1)
+	return client, clock
+}
+
+// testLogger is a retryablehttp.Logger writing to the log of a test.
+type testLogger struct {
+	t testing.TB
+}
+
+func (l testLogger) Printf(format string, args ...interface{}) {
+	l.t.Helper()
+	l.t.Logf(format, args...)
+}
+
+// AssertBackoffSchedule fails the test unless the waits between attempts
+// made with clock were exactly those given.
+func AssertBackoffSchedule(t testing.TB, clock *retryablehttp.FakeClock, want ...time.Duration) {
+	t.Helper()
+	got := clock.Waits()
+	if len(got) == 0 && len(want) == 0 {
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected backoff schedule %v, got %v", want, got)
+	}
+}
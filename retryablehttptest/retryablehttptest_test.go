@@ -0,0 +1,120 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttptest
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+)
+
+func TestServer_script(t *testing.T) {
+	srv := NewServer(t,
+		Status(http.StatusServiceUnavailable).WithHeader("Retry-After", "2"),
+		ResetConnection(),
+		Status(http.StatusBadGateway),
+		Status(http.StatusOK).WithBody("done"),
+	)
+	client, clock := NewClient(t)
+	client.RetryWaitMin = time.Second
+	client.RetryWaitMax = time.Minute
+
+	req, err := retryablehttp.NewRequest("PUT", srv.URL+"/items?id=1", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	req.Header.Set("X-Test", "yes")
+	_, info, err := client.DoWithInfo(req)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	srv.AssertAttempts(t, 4)
+	srv.AssertBodies(t, "payload")
+	srv.AssertHeader(t, "X-Test", "yes")
+	AssertBackoffSchedule(t, clock, 2*time.Second, 2*time.Second, 4*time.Second)
+
+	if a := srv.Attempts()[0]; a.Method != "PUT" || a.Path != "/items?id=1" {
+		t.Fatalf("unexpected attempt %#v", a)
+	}
+	if err := info.Attempts[1].Err; !errors.Is(err, syscall.ECONNRESET) && !errors.Is(err, io.EOF) {
+		t.Fatalf("expected the connection to be reset, got %v", err)
+	}
+
+	// The last step is repeated once the script is exhausted.
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "done" {
+		t.Fatalf("expected the last step to be repeated, got %q", body)
+	}
+	srv.AssertAttempts(t, 5)
+}
+
+func TestServer_hang(t *testing.T) {
+	srv := NewServer(t, Hang(time.Minute), Status(http.StatusOK))
+	client, clock := NewClient(t)
+	client.AttemptTimeout = 20 * time.Millisecond
+
+	start := time.Now()
+	if _, err := client.Get(srv.URL); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("expected the hang to end with the attempt, took %s", elapsed)
+	}
+	srv.AssertAttempts(t, 2)
+	AssertBackoffSchedule(t, clock, time.Second)
+}
+
+func TestNewServer_default(t *testing.T) {
+	srv := NewServer(t)
+	client, clock := NewClient(t)
+	if _, err := client.Get(srv.URL); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	srv.AssertAttempts(t, 1)
+	AssertBackoffSchedule(t, clock)
+}
+
+func TestNewClient_reproducible(t *testing.T) {
+	schedule := func() []time.Duration {
+		srv := NewServer(t, Status(http.StatusServiceUnavailable))
+		client, clock := NewClient(t)
+		client.Backoff = retryablehttp.LinearJitterBackoff
+		client.Get(srv.URL)
+		return clock.Waits()
+	}
+	first, second := schedule(), schedule()
+	if len(first) != 4 || !reflect.DeepEqual(first, second) {
+		t.Fatalf("expected the same jittered schedule on every run, got %v and %v", first, second)
+	}
+}
+
+func TestStep_String(t *testing.T) {
+	tests := []struct {
+		step Step
+		want string
+	}{
+		{Status(503), "503 Service Unavailable"},
+		{Status(200).WithBody("x"), "200 OK"},
+		{ResetConnection(), "connection reset"},
+		{Hang(5 * time.Second), "hang 5s"},
+	}
+	for _, tt := range tests {
+		if got := tt.step.String(); got != tt.want {
+			t.Fatalf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
@@ -43,6 +43,9 @@ func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 		return nil, err
 	}
 
+	// Apply any retry settings carried by the request context.
+	applyRetryOptions(retryableReq)
+
 	// Execute the request.
 	resp, err := rt.Client.Do(retryableReq)
 	// If we got an error returned by standard library's `Do` method, unwrap it
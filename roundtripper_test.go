@@ -14,6 +14,7 @@ import (
 	"reflect"
 	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestRoundTripper_implements(t *testing.T) {
@@ -144,3 +145,56 @@ func normalizeError(err error) error {
 
 	return err
 }
+
+func TestRoundTripper_RetryOptions(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryMax = 1
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.ErrorHandler = PassthroughErrorHandler
+	std := client.StandardClient()
+
+	var backoffs int32
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		attempts int32
+	}{
+		{"client_settings", context.Background(), 2},
+		{"without_retries", WithoutRetries(context.Background()), 1},
+		{"retry_max", WithRetryOptions(context.Background(), RetryMax(3)), 4},
+		{"later_options_win", WithRetryOptions(WithoutRetries(context.Background()), RetryMax(2)), 3},
+		{"check_retry", WithRetryOptions(context.Background(), RetryMax(3), RetryCheck(RetryOnStatus(500))), 1},
+		{"backoff", WithRetryOptions(context.Background(), RetryWait(0, 0), RetryBackoff(func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+			atomic.AddInt32(&backoffs, 1)
+			return 0
+		})), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&hits, 0)
+			req, err := http.NewRequestWithContext(tt.ctx, "GET", ts.URL, nil)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			resp, err := std.Do(req)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			resp.Body.Close()
+			if got := atomic.LoadInt32(&hits); got != tt.attempts {
+				t.Fatalf("expected %d attempts, got %d", tt.attempts, got)
+			}
+		})
+	}
+	if backoffs != 1 {
+		t.Fatalf("expected the context's backoff to be used once, got %d", backoffs)
+	}
+}
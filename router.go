@@ -0,0 +1,174 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// RetryPolicy is a bundle of retry settings which a PolicyRouter applies to
+// the requests matching a route. RetryMax, RetryWaitMin and RetryWaitMax are
+// always used as given, while a nil CheckRetry, CheckRetryV2 or Backoff falls
+// back to the one configured on the Client.
+type RetryPolicy struct {
+	RetryMax     int           // Maximum number of retries
+	RetryWaitMin time.Duration // Minimum time to wait
+	RetryWaitMax time.Duration // Maximum time to wait
+
+	CheckRetry   CheckRetry
+	CheckRetryV2 CheckRetryV2
+	Backoff      Backoff
+}
+
+// PolicyRoute selects the requests a RetryPolicy applies to. Empty fields
+// match any request.
+type PolicyRoute struct {
+	// Host is matched against the host of the request URL, both with and
+	// without the port, using the syntax of path.Match, so that
+	// "*.example.com" matches any subdomain. Hosts are compared
+	// case-insensitively.
+	Host string
+
+	// Path is matched against the path of the request URL using the syntax
+	// of path.Match. Like the patterns of http.ServeMux, a pattern ending in
+	// a slash matches every path beneath it, so "/v1/*/items/" matches
+	// "/v1/users/items/42".
+	Path string
+
+	// Methods are the HTTP methods the route applies to.
+	Methods []string
+
+	// Policy holds the retry settings of the matching requests.
+	Policy RetryPolicy
+
+	// pathPrefix, if set, must start the path of the request URL. It is
+	// the path_prefix of an override in a policy document.
+	pathPrefix string
+}
+
+// PolicyRouter picks the retry settings of each request from a list of
+// routes, so that a single Client, and its connection pool, can talk to
+// upstreams needing different retry behavior. Routes are tried in the order
+// they were added and the first matching one is used. Requests matching no
+// route use the settings of the Client.
+//
+// Routes must be added before the router is used by a Client.
+type PolicyRouter struct {
+	routes []PolicyRoute
+}
+
+// NewPolicyRouter returns an empty PolicyRouter.
+func NewPolicyRouter() *PolicyRouter {
+	return &PolicyRouter{}
+}
+
+// Add appends a route to the router. It returns an error if a pattern of the
+// route is malformed.
+func (r *PolicyRouter) Add(route PolicyRoute) error {
+	route, err := compileRoute(route)
+	if err != nil {
+		return err
+	}
+	r.routes = append(r.routes, route)
+	return nil
+}
+
+// compileRoute checks the patterns of route, and returns it normalized for
+// matches.
+func compileRoute(route PolicyRoute) (PolicyRoute, error) {
+	if _, err := path.Match(strings.ToLower(route.Host), ""); err != nil {
+		return route, fmt.Errorf("invalid host pattern %q: %w", route.Host, err)
+	}
+	if _, err := path.Match(route.Path, ""); err != nil {
+		return route, fmt.Errorf("invalid path pattern %q: %w", route.Path, err)
+	}
+
+	route.Host = strings.ToLower(route.Host)
+	methods := make([]string, len(route.Methods))
+	for i, method := range route.Methods {
+		methods[i] = strings.ToUpper(method)
+	}
+	route.Methods = methods
+	return route, nil
+}
+
+// Match returns the retry settings of the first route matching req. The
+// returned bool is false if no route matches.
+func (r *PolicyRouter) Match(req *http.Request) (RetryPolicy, bool) {
+	if r == nil || req == nil || req.URL == nil {
+		return RetryPolicy{}, false
+	}
+	for _, route := range r.routes {
+		if route.matches(req) {
+			return route.Policy, true
+		}
+	}
+	return RetryPolicy{}, false
+}
+
+// matches reports whether the route applies to req.
+func (route *PolicyRoute) matches(req *http.Request) bool {
+	if len(route.Methods) > 0 {
+		method := req.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+		found := false
+		for _, m := range route.Methods {
+			if m == method {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if route.Host != "" {
+		host := strings.ToLower(req.URL.Host)
+		hostname := strings.ToLower(req.URL.Hostname())
+		hostOK, _ := path.Match(route.Host, host)
+		hostnameOK, _ := path.Match(route.Host, hostname)
+		if !hostOK && !hostnameOK {
+			return false
+		}
+	}
+
+	if route.pathPrefix != "" && !strings.HasPrefix(req.URL.Path, route.pathPrefix) {
+		return false
+	}
+
+	if route.Path != "" {
+		p := req.URL.Path
+		if p == "" {
+			p = "/"
+		}
+		if strings.HasSuffix(route.Path, "/") {
+			// Compare only as many path segments as the pattern has.
+			depth := strings.Count(route.Path, "/")
+			if strings.Count(p, "/") < depth {
+				return false
+			}
+			n := 0
+			for i := range p {
+				if p[i] == '/' {
+					n++
+					if n == depth {
+						p = p[:i+1]
+						break
+					}
+				}
+			}
+		}
+		if ok, _ := path.Match(route.Path, p); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPolicyRouter_Match(t *testing.T) {
+	router := NewPolicyRouter()
+	routes := []PolicyRoute{
+		{Host: "api.example.com", Path: "/v1/*/items/", Methods: []string{"get"}, Policy: RetryPolicy{RetryMax: 1}},
+		{Host: "*.EXAMPLE.com:8443", Policy: RetryPolicy{RetryMax: 2}},
+		{Path: "/health", Policy: RetryPolicy{RetryMax: 3}},
+		{Host: "*.example.com", Methods: []string{"POST", "PUT"}, Policy: RetryPolicy{RetryMax: 4}},
+	}
+	for _, route := range routes {
+		if err := router.Add(route); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	tests := []struct {
+		method   string
+		url      string
+		retryMax int
+	}{
+		{"GET", "http://api.example.com/v1/users/items/42", 1},
+		{"", "http://API.example.com:80/v1/users/items/", 1},
+		{"GET", "http://api.example.com/v1/users/items", -1},
+		{"GET", "http://api.example.com/v1/users/things/42", -1},
+		{"GET", "http://www.example.com:8443/anything", 2},
+		{"GET", "http://www.example.com/anything", -1},
+		{"POST", "http://www.example.com/anything", 4},
+		{"GET", "http://other.test/health", 3},
+		{"GET", "http://other.test/health/deep", -1},
+		{"PUT", "http://example.com/", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.url, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatalf("err: %v", err)
+			}
+			policy, ok := router.Match(req)
+			if tt.retryMax < 0 {
+				if ok {
+					t.Fatalf("expected no match, got %#v", policy)
+				}
+				return
+			}
+			if !ok || policy.RetryMax != tt.retryMax {
+				t.Fatalf("expected RetryMax %d, got %d (matched: %t)", tt.retryMax, policy.RetryMax, ok)
+			}
+		})
+	}
+
+	if err := router.Add(PolicyRoute{Path: "/v1/["}); err == nil || !strings.Contains(err.Error(), "invalid path pattern") {
+		t.Fatalf("expected an invalid path pattern error, got %v", err)
+	}
+	if err := router.Add(PolicyRoute{Host: "[a-"}); err == nil || !strings.Contains(err.Error(), "invalid host pattern") {
+		t.Fatalf("expected an invalid host pattern error, got %v", err)
+	}
+}
+
+func TestClient_Router(t *testing.T) {
+	var hits int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	var checked, backedOff int32
+	router := NewPolicyRouter()
+	if err := router.Add(PolicyRoute{
+		Path: "/flaky/",
+		Policy: RetryPolicy{
+			RetryMax: 4,
+			Backoff: func(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
+				atomic.AddInt32(&backedOff, 1)
+				return time.Millisecond
+			},
+		},
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := router.Add(PolicyRoute{
+		Path: "/fragile",
+		Policy: RetryPolicy{
+			RetryMax: 3,
+			CheckRetry: func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+				atomic.AddInt32(&checked, 1)
+				return false, nil
+			},
+		},
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	client := NewClient()
+	client.RetryMax = 1
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.Router = router
+
+	tests := []struct {
+		path     string
+		attempts int
+	}{
+		{"/flaky/a", 5},
+		{"/fragile", 1},
+		{"/other", 2},
+	}
+	for _, tt := range tests {
+		atomic.StoreInt32(&hits, 0)
+		_, info, _ := client.DoWithInfo(mustNewRequest(t, "GET", ts.URL+tt.path))
+		if info.NumAttempts() != tt.attempts || int(atomic.LoadInt32(&hits)) != tt.attempts {
+			t.Fatalf("%s: expected %d attempts, got %d", tt.path, tt.attempts, info.NumAttempts())
+		}
+	}
+	if backedOff != 4 {
+		t.Fatalf("expected the route's Backoff to be used 4 times, got %d", backedOff)
+	}
+	if checked != 1 {
+		t.Fatalf("expected the route's CheckRetry to be used once, got %d", checked)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+)
+
+// retryOverrides are the retry settings made on a Request. Nil values are
+// taken from the Client.
+type retryOverrides struct {
+	retryMax       *int
+	retryWaitMin   *time.Duration
+	retryWaitMax   *time.Duration
+	checkRetry     CheckRetry
+	backoff        Backoff
+	prepareRetry   PrepareRetry
+	maxElapsedTime *time.Duration
+	attemptTimeout *time.Duration
+}
+
+// isZero reports whether no retry setting was overridden.
+func (o retryOverrides) isZero() bool {
+	return o.retryMax == nil && o.retryWaitMin == nil && o.retryWaitMax == nil &&
+		o.checkRetry == nil && o.backoff == nil && o.prepareRetry == nil &&
+		o.maxElapsedTime == nil && o.attemptTimeout == nil
+}
+
+// retrySettings are the retry settings in effect for an attempt, after
+// applying the router of the client and the overrides of the request.
+type retrySettings struct {
+	retryMax     int
+	retryWaitMin time.Duration
+	retryWaitMax time.Duration
+	checkRetry   CheckRetry
+	checkRetryV2 CheckRetryV2
+	backoff      randBackoff
+	prepareRetry PrepareRetry
+
+	newBackoffStrategy func() BackoffStrategy
+
+	maxElapsedTime           time.Duration
+	attemptTimeout           time.Duration
+	attemptTimeoutEscalation float64
+}
+
+// retrySettings returns the retry settings for the next attempt of req.
+func (c *Client) retrySettings(req *Request) retrySettings {
+	s := retrySettings{
+		retryMax:     c.RetryMax,
+		retryWaitMin: c.RetryWaitMin,
+		retryWaitMax: c.RetryWaitMax,
+		checkRetry:   c.CheckRetry,
+		checkRetryV2: c.CheckRetryV2,
+		backoff:      resolveBackoff(c.Backoff),
+		prepareRetry: c.PrepareRetry,
+
+		newBackoffStrategy: c.NewBackoffStrategy,
+
+		maxElapsedTime:           c.MaxElapsedTime,
+		attemptTimeout:           c.AttemptTimeout,
+		attemptTimeoutEscalation: c.AttemptTimeoutEscalation,
+	}
+
+	if policy, ok := c.Router.Match(req.Request); ok {
+		s.retryMax = policy.RetryMax
+		s.retryWaitMin = policy.RetryWaitMin
+		s.retryWaitMax = policy.RetryWaitMax
+		switch {
+		case policy.CheckRetryV2 != nil:
+			s.checkRetry, s.checkRetryV2 = nil, policy.CheckRetryV2
+		case policy.CheckRetry != nil:
+			s.checkRetry, s.checkRetryV2 = policy.CheckRetry, nil
+		}
+		if policy.Backoff != nil {
+			s.backoff, s.newBackoffStrategy = resolveBackoff(policy.Backoff), nil
+		}
+	}
+
+	o := req.overrides
+	if o.retryMax != nil {
+		s.retryMax = *o.retryMax
+	}
+	if o.retryWaitMin != nil {
+		s.retryWaitMin = *o.retryWaitMin
+	}
+	if o.retryWaitMax != nil {
+		s.retryWaitMax = *o.retryWaitMax
+	}
+	if o.checkRetry != nil {
+		s.checkRetry, s.checkRetryV2 = o.checkRetry, nil
+	}
+	if o.backoff != nil {
+		s.backoff, s.newBackoffStrategy = resolveBackoff(o.backoff), nil
+	}
+	if o.prepareRetry != nil {
+		s.prepareRetry = o.prepareRetry
+	}
+	if o.maxElapsedTime != nil {
+		s.maxElapsedTime = *o.maxElapsedTime
+	}
+	if o.attemptTimeout != nil {
+		s.attemptTimeout = *o.attemptTimeout
+	}
+	return s
+}
+
+// check asks the policy in effect whether the attempt of req should be
+// retried.
+func (s *retrySettings) check(ctx context.Context, req *http.Request, resp *http.Response, err error, attempt AttemptInfo) RetryDecision {
+	if s.checkRetryV2 != nil {
+		return s.checkRetryV2(ctx, req, resp, err, attempt)
+	}
+	return AdaptCheckRetry(s.checkRetry)(ctx, req, resp, err, attempt)
+}
+
+// attemptContext returns the context bounding the request made for the
+// given attempt, which is ctx itself if there is no attempt timeout.
+func (s *retrySettings) attemptContext(ctx context.Context, attempt AttemptInfo) (context.Context, context.CancelFunc) {
+	if s.attemptTimeout <= 0 {
+		return ctx, func() {}
+	}
+	timeout := s.attemptTimeout
+	if s.attemptTimeoutEscalation > 1 {
+		scaled := float64(timeout) * math.Pow(s.attemptTimeoutEscalation, float64(attempt.Number-1))
+		if scaled < math.MaxInt64 {
+			timeout = time.Duration(scaled)
+		} else {
+			timeout = math.MaxInt64
+		}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// RetryOption changes a retry setting of a Request. RetryOptions are carried
+// in a context with WithRetryOptions, for code which only has access to the
+// *http.Client returned by Client.StandardClient.
+type RetryOption func(*Request)
+
+// RetryMax returns a RetryOption which calls Request.SetRetryMax.
+func RetryMax(retryMax int) RetryOption {
+	return func(r *Request) { r.SetRetryMax(retryMax) }
+}
+
+// RetryWait returns a RetryOption which calls Request.SetRetryWait.
+func RetryWait(min, max time.Duration) RetryOption {
+	return func(r *Request) { r.SetRetryWait(min, max) }
+}
+
+// RetryCheck returns a RetryOption which calls Request.SetCheckRetry.
+func RetryCheck(fn CheckRetry) RetryOption {
+	return func(r *Request) { r.SetCheckRetry(fn) }
+}
+
+// RetryBackoff returns a RetryOption which calls Request.SetBackoff.
+func RetryBackoff(fn Backoff) RetryOption {
+	return func(r *Request) { r.SetBackoff(fn) }
+}
+
+// RetryPrepare returns a RetryOption which calls Request.SetPrepareRetry.
+func RetryPrepare(fn PrepareRetry) RetryOption {
+	return func(r *Request) { r.SetPrepareRetry(fn) }
+}
+
+// retryOptionsKey is the context key of the RetryOptions added by
+// WithRetryOptions.
+type retryOptionsKey struct{}
+
+// WithRetryOptions returns a copy of ctx carrying the given RetryOptions in
+// addition to any already carried by ctx. RoundTripper, and so the client
+// returned by Client.StandardClient, applies them to each request made with
+// the context, later options taking precedence.
+func WithRetryOptions(ctx context.Context, opts ...RetryOption) context.Context {
+	prev := retryOptionsFromContext(ctx)
+	all := make([]RetryOption, 0, len(prev)+len(opts))
+	all = append(all, prev...)
+	all = append(all, opts...)
+	return context.WithValue(ctx, retryOptionsKey{}, all)
+}
+
+// WithoutRetries returns a copy of ctx with which requests made through a
+// RoundTripper are attempted only once.
+func WithoutRetries(ctx context.Context) context.Context {
+	return WithRetryOptions(ctx, RetryMax(0))
+}
+
+// retryOptionsFromContext returns the RetryOptions carried by ctx.
+func retryOptionsFromContext(ctx context.Context) []RetryOption {
+	opts, _ := ctx.Value(retryOptionsKey{}).([]RetryOption)
+	return opts
+}
+
+// applyRetryOptions applies the RetryOptions carried by the context of r.
+func applyRetryOptions(r *Request) {
+	for _, opt := range retryOptionsFromContext(r.Context()) {
+		opt(r)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AdaptiveThrottle rejects attempts locally, before they are sent, when the
+// upstream has been rejecting many of them, as described in the "Handling
+// Overload" chapter of the Google SRE book. For each key, by default the host
+// of the request, it counts the attempts made and those accepted over a
+// sliding window, and rejects new attempts with probability
+//
+//	max(0, (requests - K*accepts) / (requests + 1))
+//
+// An attempt is accepted unless the retry policy of the client wants to
+// retry it. Attempts rejected locally count as requests too, so the
+// rejection rate follows the load the upstream would have seen.
+//
+// An AdaptiveThrottle is safe for concurrent use. Its fields must not be
+// changed once it is in use.
+type AdaptiveThrottle struct {
+	// K is how many requests per accepted request are let through before
+	// rejecting any. Lower values reject sooner. It defaults to 2.
+	K float64
+
+	// Window is the period over which requests and accepts are counted. It
+	// defaults to 2 minutes.
+	Window time.Duration
+
+	// Key returns the key of the counts of a request. It defaults to the
+	// host of the request URL.
+	Key func(*http.Request) string
+
+	mu      sync.Mutex
+	windows map[string]*slidingWindow
+}
+
+// NewAdaptiveThrottle returns an AdaptiveThrottle with a K of 2 and a window
+// of 2 minutes.
+func NewAdaptiveThrottle() *AdaptiveThrottle {
+	return &AdaptiveThrottle{K: 2, Window: 2 * time.Minute}
+}
+
+// The kinds of events counted by an AdaptiveThrottle.
+const (
+	throttleRequests = iota
+	throttleAccepts
+)
+
+func (t *AdaptiveThrottle) key(req *http.Request) string {
+	if t.Key != nil {
+		return t.Key(req)
+	}
+	return req.URL.Host
+}
+
+func (t *AdaptiveThrottle) k() float64 {
+	if t.K > 0 {
+		return t.K
+	}
+	return 2
+}
+
+func (t *AdaptiveThrottle) window() time.Duration {
+	if t.Window > 0 {
+		return t.Window
+	}
+	return 2 * time.Minute
+}
+
+// counts returns the window of the given key. The throttle must be locked.
+func (t *AdaptiveThrottle) counts(key string) *slidingWindow {
+	if t.windows == nil {
+		t.windows = make(map[string]*slidingWindow)
+	}
+	w, ok := t.windows[key]
+	if !ok {
+		w = &slidingWindow{}
+		t.windows[key] = w
+	}
+	return w
+}
+
+// probability returns the probability of rejecting an attempt given the
+// counts of its key. The throttle must be locked.
+func (t *AdaptiveThrottle) probability(w *slidingWindow, now time.Time) float64 {
+	requests, accepts := w.sums(now, t.window())
+	return math.Max(0, (float64(requests)-t.k()*float64(accepts))/float64(requests+1))
+}
+
+// Probability returns the probability with which an attempt with the given
+// key is rejected at now.
+func (t *AdaptiveThrottle) Probability(key string, now time.Time) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.probability(t.counts(key), now)
+}
+
+// allow decides whether to let an attempt with the given key through at
+// now, drawing from rnd, or returns a *ThrottledError. The attempt must be
+// followed by a call to record if it is let through.
+func (t *AdaptiveThrottle) allow(key string, rnd Rand, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := t.counts(key)
+	p := t.probability(w, now)
+	if p > 0 && rnd.Float64() < p {
+		w.add(now, t.window(), throttleRequests)
+		return &ThrottledError{Key: key, Probability: p}
+	}
+	return nil
+}
+
+// record counts an attempt let through by allow, and whether it was
+// accepted.
+func (t *AdaptiveThrottle) record(key string, accepted bool, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w := t.counts(key)
+	w.add(now, t.window(), throttleRequests)
+	if accepted {
+		w.add(now, t.window(), throttleAccepts)
+	}
+}
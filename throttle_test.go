@@ -0,0 +1,163 @@
+// Copyright IBM Corp. 2015, 2025
+// SPDX-License-Identifier: MPL-2.0
+
+package retryablehttp
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fixedRand is a Rand always drawing the same number.
+type fixedRand float64
+
+func (r fixedRand) Int63n(n int64) int64 { return int64(float64(r) * float64(n)) }
+func (r fixedRand) Float64() float64     { return float64(r) }
+
+func TestAdaptiveThrottle(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	throttle := &AdaptiveThrottle{K: 2, Window: time.Minute}
+
+	if err := throttle.allow("a", fixedRand(0), now); err != nil {
+		t.Fatalf("expected nothing to be rejected without requests, got %v", err)
+	}
+
+	// Two requests per accept are let through.
+	for i := 0; i < 10; i++ {
+		throttle.record("a", i%2 == 0, now)
+	}
+	if p := throttle.Probability("a", now); p != 0 {
+		t.Fatalf("expected no rejections, got probability %f", p)
+	}
+	for i := 0; i < 10; i++ {
+		throttle.record("a", false, now)
+	}
+	// (20 - 2*5) / 21
+	if p := throttle.Probability("a", now); math.Abs(p-10.0/21) > 1e-9 {
+		t.Fatalf("expected probability 10/21, got %f", p)
+	}
+	if err := throttle.allow("a", fixedRand(0.5), now); err != nil {
+		t.Fatalf("expected a draw above the probability to be let through, got %v", err)
+	}
+
+	err := throttle.allow("a", fixedRand(0.4), now)
+	var throttledErr *ThrottledError
+	if !errors.As(err, &throttledErr) || !errors.Is(err, ErrThrottled) {
+		t.Fatalf("expected a *ThrottledError, got %v", err)
+	}
+	if throttledErr.Key != "a" || math.Abs(throttledErr.Probability-10.0/21) > 1e-9 {
+		t.Fatalf("unexpected error %#v", throttledErr)
+	}
+	// The rejection counts as a request.
+	if p := throttle.Probability("a", now); math.Abs(p-11.0/22) > 1e-9 {
+		t.Fatalf("expected probability 11/22, got %f", p)
+	}
+
+	// Other keys are unaffected, and counts expire with the window.
+	if p := throttle.Probability("b", now); p != 0 {
+		t.Fatalf("expected no rejections for another key, got probability %f", p)
+	}
+	if p := throttle.Probability("a", now.Add(time.Minute)); p != 0 {
+		t.Fatalf("expected the counts to expire, got probability %f", p)
+	}
+}
+
+func TestClient_Throttle(t *testing.T) {
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	client := NewClient()
+	client.RetryMax = 10
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.Rand = fixedRand(0.5)
+	client.Throttle = NewAdaptiveThrottle()
+
+	// With every attempt rejected by the upstream, the probability of
+	// rejecting the next one locally is n/(n+1) after n attempts, which
+	// exceeds the draw of 0.5 once two attempts were made.
+	_, err := client.Get(ts.URL)
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || !errors.Is(err, ErrThrottled) {
+		t.Fatalf("expected the retries to be throttled, got %v", err)
+	}
+	if len(retryErr.Attempts) != 2 || atomic.LoadInt32(&requests) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(retryErr.Attempts))
+	}
+	if !strings.Contains(err.Error(), "503") {
+		t.Fatalf("expected the error of the last attempt, got %v", err)
+	}
+
+	// New requests are rejected before being sent.
+	_, info, err := client.DoWithInfo(mustNewRequest(t, "GET", ts.URL))
+	var throttledErr *ThrottledError
+	if !errors.As(err, &throttledErr) || len(info.Attempts) != 0 {
+		t.Fatalf("expected the request to be throttled, got %v", err)
+	}
+	if throttledErr.Key != strings.TrimPrefix(ts.URL, "http://") {
+		t.Fatalf("expected the host as key, got %q", throttledErr.Key)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("expected 2 requests, got %d", got)
+	}
+}
+
+func TestClient_Throttle_retry(t *testing.T) {
+	newClient := func() *Client {
+		client := NewClient()
+		client.RetryMax = 10
+		client.RetryWaitMin = time.Millisecond
+		client.RetryWaitMax = time.Millisecond
+		client.Rand = fixedRand(0.5)
+		client.Throttle = NewAdaptiveThrottle()
+		return client
+	}
+
+	// A throttled retry keeps the error of the last attempt.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, err = newClient().Get("http://" + addr)
+	if !errors.Is(err, ErrThrottled) || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Fatalf("expected the retries to be throttled after the connection was refused, got %v", err)
+	}
+
+	// And its response, which is still readable.
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "unavailable")
+	}))
+	defer ts.Close()
+	client := newClient()
+	client.ErrorHandler = PassthroughErrorHandler
+	resp, err := client.Get(ts.URL)
+	if err == nil || resp == nil {
+		t.Fatalf("expected the response and error of the last attempt, got %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("expected the retries to be throttled after 2 requests, got %d", got)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "unavailable" {
+		t.Fatalf("expected the body of the last attempt, got %q, %v", body, err)
+	}
+}